@@ -1,6 +1,10 @@
 package ifttt
 
-import "github.com/Jeffail/gabs"
+import (
+	"context"
+
+	"github.com/Jeffail/gabs"
+)
 
 // ActionResult returns the result of an activity
 // https://platform.ifttt.com/docs/api_reference#actions
@@ -16,9 +20,16 @@ type ActionResult struct {
 type ActionHandleRequest struct {
 	// ActionFields are the parameters set in an applet
 	ActionFields map[string]string
+	// Fields are the decoded values of ActionFields, typed according to the FieldSchema if the action implements SchemaProvider
+	Fields FieldValues
 	// User contains the metadata of the IFTTT user (eg: timezone)
 	User map[string]string
-	// TODO: IFTTT source
+	// UserContext contains the timezone of the IFTTT user resolved from User
+	UserContext UserContext
+	// Source describes the applet which initiated this request
+	Source Source
+	// Metadata contains other top level keys of the request body sent by IFTTT
+	Metadata map[string]interface{}
 }
 
 func (c *ActionResult) marshal() []byte {
@@ -46,3 +57,25 @@ type Action interface {
 	// If there is a problem with the request that you cannot handle(eg: conflicting parameters), set skip to true and return the error, IFTTT will notify the user with the description of your error and give up.
 	Handle(r *ActionHandleRequest, req *Request) (res *ActionResult, skip bool, err error)
 }
+
+// ActionWithContext is the context-aware variant of Action.
+// Register it with Service.RegisterActionWithContext.
+type ActionWithContext interface {
+	// OptionsWithContext is the context-aware variant of Action.Options
+	OptionsWithContext(ctx context.Context, req *Request) (*DynamicOption, error)
+	// HandleWithContext is the context-aware variant of Action.Handle
+	HandleWithContext(ctx context.Context, r *ActionHandleRequest, req *Request) (res *ActionResult, skip bool, err error)
+}
+
+// actionAdapter adapts an Action to ActionWithContext by ignoring the context
+type actionAdapter struct {
+	Action
+}
+
+func (c actionAdapter) OptionsWithContext(ctx context.Context, req *Request) (*DynamicOption, error) {
+	return c.Options(req)
+}
+
+func (c actionAdapter) HandleWithContext(ctx context.Context, r *ActionHandleRequest, req *Request) (res *ActionResult, skip bool, err error) {
+	return c.Handle(r, req)
+}
@@ -0,0 +1,208 @@
+package ifttt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Principal is the user resolved from the access token of a request
+type Principal struct {
+	// ID the id of the user
+	ID string
+	// Attributes arbitrary data attached by the Authenticator (eg: scopes, account records)
+	Attributes map[string]interface{}
+}
+
+// Authenticator resolves the access token of authenticated requests into a Principal before handlers are called
+type Authenticator interface {
+	// Authenticate returns the principal owning token, or an AuthError if the token is invalid
+	// Other errors are treated as internal errors and are not cached by CachingAuthenticator.
+	Authenticate(ctx context.Context, token string, req *Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function into an Authenticator
+type AuthenticatorFunc func(ctx context.Context, token string, req *Request) (*Principal, error)
+
+// Authenticate implements Authenticator
+func (c AuthenticatorFunc) Authenticate(ctx context.Context, token string, req *Request) (*Principal, error) {
+	return c(ctx, token, req)
+}
+
+// AuthRequirer can be optionally implemented by a Trigger, Action or Query to declare whether its requests must carry a valid access token.
+// Handlers which do not implement it require authentication whenever the Service has an Authenticator.
+type AuthRequirer interface {
+	// RequiresAuth returns whether requests without an access token should be refused
+	RequiresAuth() bool
+}
+
+// Authenticate implements Authenticator, resolving access tokens issued by the provider
+func (c *OAuth2Provider) Authenticate(ctx context.Context, token string, req *Request) (*Principal, error) {
+	res, err := c.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		ID: res.UserID,
+		Attributes: map[string]interface{}{
+			"client_id": res.ClientID,
+		},
+	}, nil
+}
+
+type authCacheEntry struct {
+	principal *Principal
+	err       error
+	expires   time.Time
+}
+
+// CachingAuthenticator caches the results of another Authenticator
+// Resolved principals are cached for TTL and refused tokens (AuthError) for NegativeTTL, other errors are never cached.
+type CachingAuthenticator struct {
+	auth        Authenticator
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	lock      sync.Mutex
+	entries   map[string]authCacheEntry
+	lastSweep time.Time
+}
+
+// NewCachingAuthenticator creates a CachingAuthenticator caching the results of auth, a TTL of 0 disables the caching of the corresponding results
+// If auth is an *OAuth2Provider, its Revoked hook is chained to invalidate revoked and rotated tokens immediately.
+// Other authenticators should call Invalidate when their tokens are revoked, or cached tokens stay valid until the TTL expires.
+func NewCachingAuthenticator(auth Authenticator, ttl time.Duration, negativeTTL time.Duration) *CachingAuthenticator {
+	res := &CachingAuthenticator{
+		auth:        auth,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]authCacheEntry),
+		lastSweep:   time.Now(),
+	}
+	if provider, ok := auth.(*OAuth2Provider); ok {
+		revoked := provider.Revoked
+		provider.Revoked = func(token OAuth2Token) {
+			if revoked != nil {
+				revoked(token)
+			}
+			res.Invalidate(token.AccessToken)
+		}
+	}
+	return res
+}
+
+// sweep drops expired entries at most once per max(ttl, negativeTTL), c.lock must be held
+func (c *CachingAuthenticator) sweep(now time.Time) {
+	interval := c.ttl
+	if c.negativeTTL > interval {
+		interval = c.negativeTTL
+	}
+	if now.Sub(c.lastSweep) < interval {
+		return
+	}
+	for token, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, token)
+		}
+	}
+	c.lastSweep = now
+}
+
+// Authenticate implements Authenticator
+func (c *CachingAuthenticator) Authenticate(ctx context.Context, token string, req *Request) (*Principal, error) {
+	now := time.Now()
+	c.lock.Lock()
+	entry, ok := c.entries[token]
+	c.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.principal, entry.err
+	}
+
+	principal, err := c.auth.Authenticate(ctx, token, req)
+	ttl := c.ttl
+	if err != nil {
+		if !errors.As(err, &AuthError{}) {
+			return principal, err
+		}
+		ttl = c.negativeTTL
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sweep(now)
+	if ttl > 0 {
+		c.entries[token] = authCacheEntry{principal, err, now.Add(ttl)}
+	} else {
+		delete(c.entries, token)
+	}
+	return principal, err
+}
+
+// Invalidate drops the cached result of token (eg: after the token was revoked)
+func (c *CachingAuthenticator) Invalidate(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, token)
+}
+
+// authenticator returns the Authenticator of the service, falling back to the OAuth2 provider if set
+func (c *Service) authenticator() Authenticator {
+	if c.Authenticator != nil {
+		return c.Authenticator
+	}
+	if c.OAuth2 != nil {
+		return c.OAuth2
+	}
+	return nil
+}
+
+// requiresAuth returns whether req must carry a valid access token when an Authenticator is set
+// Only requests which IFTTT sends with the access token of the user are considered.
+func (c *Service) requiresAuth(req *Request) bool {
+	var handler interface{}
+	var ok bool
+	switch req.Type {
+	case UserInfoRequest:
+		return true
+	case TriggerFetch, TriggerDynamicOptions:
+		handler, ok = c.triggers[req.Slug]
+	case ActionTrigger, ActionDynamicOptions:
+		handler, ok = c.actions[req.Slug]
+	case QueryFetch, QueryDynamicOptions:
+		handler, ok = c.queries[req.Slug]
+	default:
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if requirer, ok := unwrapHandler(handler).(AuthRequirer); ok {
+		return requirer.RequiresAuth()
+	}
+	return true
+}
+
+// authenticate resolves the principal of req, returning an AuthError if the request should be refused
+func (c *Service) authenticate(ctx context.Context, req *Request) error {
+	auth := c.authenticator()
+	if auth == nil {
+		return nil
+	}
+	if !req.Authenticated {
+		if c.requiresAuth(req) {
+			return AuthError{"Access token does not present."}
+		}
+		return nil
+	}
+	principal, err := auth.Authenticate(ctx, req.UserAccessToken, req)
+	if err != nil {
+		return err
+	}
+	if principal == nil {
+		return AuthError{}
+	}
+	req.Principal = principal
+	req.UserID = principal.ID
+	return nil
+}
@@ -0,0 +1,134 @@
+package ifttt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPrincipalAction struct {
+	public bool
+}
+
+func (c testPrincipalAction) Options(req *Request) (*DynamicOption, error) {
+	return nil, nil
+}
+
+func (c testPrincipalAction) Handle(r *ActionHandleRequest, req *Request) (*ActionResult, bool, error) {
+	id := "anonymous"
+	if req.Principal != nil {
+		id = req.Principal.ID
+	}
+	return &ActionResult{ID: id}, false, nil
+}
+
+func (c testPrincipalAction) RequiresAuth() bool {
+	return !c.public
+}
+
+func TestCachingAuthenticator(t *testing.T) {
+	calls := 0
+	auth := NewCachingAuthenticator(AuthenticatorFunc(func(ctx context.Context, token string, req *Request) (*Principal, error) {
+		calls++
+		switch token {
+		case "good":
+			return &Principal{ID: "alice"}, nil
+		case "broken":
+			return nil, errors.New("Database down")
+		}
+		return nil, AuthError{}
+	}), time.Hour, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if principal, err := auth.Authenticate(context.Background(), "good", nil); err != nil || principal.ID != "alice" {
+			t.Errorf("Unexpected principal: %v %v\n", principal, err)
+			t.Fail()
+		}
+		if _, err := auth.Authenticate(context.Background(), "bad", nil); err == nil {
+			t.Errorf("Expected bad token to be refused\n")
+			t.Fail()
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected results to be cached, got %d calls\n", calls)
+		t.Fail()
+	}
+
+	auth.Authenticate(context.Background(), "broken", nil)
+	auth.Authenticate(context.Background(), "broken", nil)
+	if calls != 4 {
+		t.Errorf("Expected internal errors not to be cached, got %d calls\n", calls)
+		t.Fail()
+	}
+
+	auth.Invalidate("good")
+	auth.Authenticate(context.Background(), "good", nil)
+	if calls != 5 {
+		t.Errorf("Expected invalidated token to be authenticated again, got %d calls\n", calls)
+		t.Fail()
+	}
+}
+
+func TestServiceAuthenticator(t *testing.T) {
+	service := &Service{
+		ServiceKey: "servicekey",
+		Authenticator: AuthenticatorFunc(func(ctx context.Context, token string, req *Request) (*Principal, error) {
+			if token == "good" {
+				return &Principal{ID: "alice"}, nil
+			}
+			return nil, AuthError{}
+		}),
+	}
+	service.RegisterAction("private", testPrincipalAction{})
+	service.RegisterAction("public", testPrincipalAction{true})
+
+	for _, c := range []struct {
+		slug   string
+		header string
+		code   int
+		body   string
+	}{
+		{"private", "IFTTT-Service-Key: servicekey\nAuthorization: Bearer good", 200, `{"data":[{"id":"alice"}]}`},
+		{"private", "IFTTT-Service-Key: servicekey\nAuthorization: Bearer bad", 401, `{"errors":[{"message":"Token invalid"}]}`},
+		{"private", "IFTTT-Service-Key: servicekey", 401, `{"errors":[{"message":"Access token does not present."}]}`},
+		{"public", "IFTTT-Service-Key: servicekey", 200, `{"data":[{"id":"anonymous"}]}`},
+		{"public", "IFTTT-Service-Key: servicekey\nAuthorization: Bearer bad", 401, `{"errors":[{"message":"Token invalid"}]}`},
+	} {
+		req := httptest.NewRequest("POST", "/ifttt/v1/actions/"+c.slug, bytes.NewBufferString(`{"actionFields":{},"user":{}}`))
+		mockHeader(c.header, req)
+		res := httptest.NewRecorder()
+		service.ServeHTTP(res, req)
+		if res.Code != c.code || !jsonEqual(res.Body.Bytes(), []byte(c.body)) {
+			t.Errorf("Unexpected response of %s with %s: %d %s\n", c.slug, c.header, res.Code, res.Body.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestCachingAuthenticatorRevocation(t *testing.T) {
+	store := NewMemoryOAuth2Store(OAuth2Client{ID: "ifttt", Secret: "clientsecret"})
+	store.PutToken(OAuth2Token{AccessToken: "access", RefreshToken: "refresh", ClientID: "ifttt", UserID: "alice"})
+	provider := &OAuth2Provider{Store: store}
+	auth := NewCachingAuthenticator(provider, time.Hour, time.Hour)
+
+	if principal, err := auth.Authenticate(context.Background(), "access", nil); err != nil || principal.ID != "alice" {
+		t.Fatalf("Unexpected principal: %v %v\n", principal, err)
+	}
+	form := url.Values{"token": {"access"}, "client_id": {"ifttt"}, "client_secret": {"clientsecret"}}
+	req := httptest.NewRequest("POST", "/oauth2/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	provider.ServeHTTP(res, req)
+	if res.Code != 200 {
+		t.Fatalf("Unexpected revoke response: %d\n", res.Code)
+	}
+	if _, err := auth.Authenticate(context.Background(), "access", nil); err == nil {
+		t.Errorf("Expected revoked token to be refused despite the cache\n")
+		t.Fail()
+	}
+}
@@ -0,0 +1,153 @@
+package ifttt
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	typeTime            = reflect.TypeOf(time.Time{})
+	typeLocation        = reflect.TypeOf(Location{})
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Decode decodes the action fields into the struct pointed by into.
+// Struct fields are mapped by tags like `ifttt:"field_slug,required"`, untagged struct fields are left untouched.
+// Supported field types are string, integers, floats, bool, time.Time, Location, types implementing encoding.TextUnmarshaler and pointers to them.
+// Datetime values without a UTC offset are read in the timezone of the user.
+// All missing and invalid fields are reported in a single FieldErrors, which is turned into a SKIP error if returned from Action.Handle.
+func (c *ActionHandleRequest) Decode(into interface{}) error {
+	return decodeStruct(c.Fields, into, c.UserContext.location())
+}
+
+// Decode decodes the trigger fields into the struct pointed by into.
+// See ActionHandleRequest.Decode for the supported tags and types.
+func (c *TriggerPollRequest) Decode(into interface{}) error {
+	return decodeStruct(c.Fields, into, c.UserContext.location())
+}
+
+// Decode decodes the query fields into the struct pointed by into.
+// See ActionHandleRequest.Decode for the supported tags and types.
+func (c *QueryRequest) Decode(into interface{}) error {
+	return decodeStruct(c.Fields, into, c.UserContext.location())
+}
+
+// decodeStruct decodes values into the struct pointed by into according to the ifttt struct tags, datetime values without a UTC offset are read in loc
+func decodeStruct(values FieldValues, into interface{}, loc *time.Location) error {
+	ptr := reflect.ValueOf(into)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return errors.New("Decode target must be a non-nil pointer to a struct")
+	}
+	target := ptr.Elem()
+	errs := make(FieldErrors, 0)
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		tag, ok := field.Tag.Lookup("ifttt")
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		slug, required := opts[0], false
+		for _, opt := range opts[1:] {
+			if opt == "required" {
+				required = true
+			}
+		}
+
+		val := values[slug]
+		if isEmpty(val) {
+			if required {
+				errs = append(errs, FieldError{slug, "is required"})
+			}
+			continue
+		}
+		if err := decodeValue(val, target.Field(i), loc); err != nil {
+			errs = append(errs, FieldError{slug, err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeValue converts a field value into dst
+func decodeValue(val interface{}, dst reflect.Value, loc *time.Location) error {
+	if dst.Kind() == reflect.Ptr {
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(val, elem.Elem(), loc); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+
+	if dst.Type() == typeTime {
+		switch v := val.(type) {
+		case time.Time:
+			dst.Set(reflect.ValueOf(v))
+			return nil
+		case string:
+			parsed, err := parseDateTimeIn(v, loc)
+			if err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(parsed))
+			return nil
+		}
+		return fmt.Errorf("%s is not a valid date and time", fieldString(val))
+	}
+	if dst.Type() == typeLocation {
+		loc, err := parseLocation(val)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(loc))
+		return nil
+	}
+	if dst.CanAddr() && dst.Addr().Type().Implements(typeTextUnmarshaler) {
+		return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(fieldString(val)))
+	}
+	if reflect.TypeOf(val).AssignableTo(dst.Type()) {
+		dst.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	str := strings.TrimSpace(fieldString(val))
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(fieldString(val))
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(str)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", str)
+		}
+		dst.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(str, 10, dst.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", str)
+		}
+		dst.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(str, 10, dst.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a non-negative integer", str)
+		}
+		dst.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(str, dst.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", str)
+		}
+		dst.SetFloat(parsed)
+	default:
+		return fmt.Errorf("cannot decode into %s", dst.Type())
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package ifttt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type decodeTarget struct {
+	Title    string    `ifttt:"title,required"`
+	Count    int       `ifttt:"count"`
+	Ratio    float64   `ifttt:"ratio"`
+	Enabled  bool      `ifttt:"enabled"`
+	Due      time.Time `ifttt:"due"`
+	Limit    *uint     `ifttt:"limit"`
+	IP       net.IP    `ifttt:"ip"`
+	Optional *string   `ifttt:"optional"`
+	Ignored  string    `ifttt:"-"`
+	Untagged string
+}
+
+func TestDecode(t *testing.T) {
+	req := &ActionHandleRequest{
+		Fields: FieldValues{
+			"title":   "Hello",
+			"count":   "12",
+			"ratio":   0.5,
+			"enabled": "true",
+			"due":     "2019-01-02T15:04:05Z",
+			"limit":   3.0,
+			"ip":      "127.0.0.1",
+			"Ignored": "foo",
+		},
+	}
+	res := decodeTarget{}
+	if err := req.Decode(&res); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if res.Title != "Hello" || res.Count != 12 || res.Ratio != 0.5 || !res.Enabled || res.Limit == nil || *res.Limit != 3 || res.Optional != nil || res.Ignored != "" {
+		t.Errorf("Unexpected result: %+v\n", res)
+		t.Fail()
+	}
+	if !res.Due.Equal(time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)) || !res.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Unexpected result: %+v\n", res)
+		t.Fail()
+	}
+
+	poll := &TriggerPollRequest{
+		Fields: FieldValues{
+			"count":   "many",
+			"enabled": "maybe",
+			"ip":      "localhost",
+		},
+	}
+	err := poll.Decode(&res)
+	if errs, ok := err.(FieldErrors); !ok || len(errs) != 4 {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+
+	if err := poll.Decode(res); err == nil {
+		t.Errorf("Non-pointer target accepted")
+		t.Fail()
+	}
+}
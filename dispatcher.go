@@ -0,0 +1,350 @@
+package ifttt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxNotificationEntries is the max number of entries IFTTT accepts in a single realtime notification
+const maxNotificationEntries = 100
+
+var (
+	// ErrorDispatcherClosed is returned when notifying through a NotifyDispatcher which has been shut down
+	ErrorDispatcherClosed = errors.New("Dispatcher closed")
+	// ErrorDispatcherQueueFull is returned when notifying through a NotifyDispatcher whose queue is full, eg: while IFTTT is unavailable
+	ErrorDispatcherQueueFull = errors.New("Dispatcher queue full")
+)
+
+// DispatcherOptions configures a NotifyDispatcher
+type DispatcherOptions struct {
+	// Interval how long notifications are coalesced before they are sent, defaults to 1 second
+	Interval time.Duration
+	// QueueSize the buffer size of the notification channel, defaults to 1024
+	QueueSize int
+	// MaxRetries max number of retries of a batch which failed with a network error, a 5xx or a 429 status, defaults to 5
+	MaxRetries int
+	// MinBackoff the delay before the first retry, doubled on each retry, defaults to 1 second
+	// A Retry-After header returned by IFTTT takes precedence.
+	MinBackoff time.Duration
+	// MaxBackoff the max delay between retries, defaults to 1 minute
+	MaxBackoff time.Duration
+	// RateLimit limits the rate of requests to the IFTTT realtime API, retries included, the zero value does not limit requests
+	RateLimit RateLimit
+}
+
+// DispatcherStats contains the delivery statistics of a NotifyDispatcher
+type DispatcherStats struct {
+	// Queued number of user ids and trigger identities received
+	Queued uint64
+	// Coalesced number of received entries dropped because they were already pending
+	Coalesced uint64
+	// Batches number of batches delivered successfully
+	Batches uint64
+	// Delivered number of entries delivered successfully
+	Delivered uint64
+	// Retries number of retried requests
+	Retries uint64
+	// Failed number of entries dropped after running out of retries or hitting a non-retryable error
+	Failed uint64
+	// Rejected number of entries refused because the queue was full
+	Rejected uint64
+}
+
+type notifyEntry struct {
+	user bool
+	id   string
+}
+
+// NotifyDispatcher sends realtime notifications in the background.
+// Duplicated entries are coalesced, entries are split into batches of 100 and failed batches are retried with exponential backoff.
+type NotifyDispatcher struct {
+	send  func(ctx context.Context, evt Notification) error
+	opts  DispatcherOptions
+	queue chan notifyEntry
+	flush chan chan struct{}
+	done  chan struct{}
+	// ctx is canceled when Shutdown gives up on the pending notifications, aborting the request in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+	// bucket limits outbound requests, only used by the run goroutine
+	bucket RateLimitBucket
+
+	closeLock sync.RWMutex
+	closed    bool
+
+	statsLock sync.Mutex
+	stats     DispatcherStats
+}
+
+// NewNotifyDispatcher creates a NotifyDispatcher sending notifications through c.NotifyWithContext and starts it
+// Call Shutdown to stop it after the pending notifications are sent.
+func (c *Service) NewNotifyDispatcher(opts DispatcherOptions) *NotifyDispatcher {
+	return newNotifyDispatcher(c.NotifyWithContext, opts)
+}
+
+func newNotifyDispatcher(send func(ctx context.Context, evt Notification) error, opts DispatcherOptions) *NotifyDispatcher {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res := &NotifyDispatcher{
+		send:   send,
+		opts:   opts,
+		queue:  make(chan notifyEntry, opts.QueueSize),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go res.run()
+	return res
+}
+
+// enqueue queues entry without blocking, closeLock is only held for the non-blocking send so Shutdown is never delayed
+func (c *NotifyDispatcher) enqueue(entry notifyEntry) error {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return ErrorDispatcherClosed
+	}
+	select {
+	case c.queue <- entry:
+		return nil
+	default:
+		c.updateStats(func(stats *DispatcherStats) {
+			stats.Rejected++
+		})
+		return ErrorDispatcherQueueFull
+	}
+}
+
+// NotifyUser queues a notification that triggers owned by userid have updates
+// It returns ErrorDispatcherQueueFull instead of blocking if the queue is full.
+func (c *NotifyDispatcher) NotifyUser(userid string) error {
+	return c.enqueue(notifyEntry{true, userid})
+}
+
+// NotifyTrigger queues a notification that the trigger identified by triggerIdent has updates
+// It returns ErrorDispatcherQueueFull instead of blocking if the queue is full.
+func (c *NotifyDispatcher) NotifyTrigger(triggerIdent string) error {
+	return c.enqueue(notifyEntry{false, triggerIdent})
+}
+
+// Flush sends all queued notifications now and blocks until they are delivered or given up
+func (c *NotifyDispatcher) Flush() {
+	ack := make(chan struct{})
+	select {
+	case c.flush <- ack:
+		<-ack
+	case <-c.done:
+	}
+}
+
+// Shutdown stops accepting notifications, sends the pending ones and stops the dispatcher
+// If ctx expires before the pending notifications are sent, the request in flight is canceled, the remaining notifications are dropped and ctx.Err() is returned.
+func (c *NotifyDispatcher) Shutdown(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		c.closeLock.Lock()
+		if !c.closed {
+			c.closed = true
+			close(c.queue)
+		}
+		c.closeLock.Unlock()
+		close(closed)
+	}()
+
+	abort := func() error {
+		c.cancel()
+		return ctx.Err()
+	}
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		// the queue is closed in the background once the lock is acquired
+		return abort()
+	}
+	select {
+	case <-c.done:
+		c.cancel()
+		return nil
+	case <-ctx.Done():
+		err := abort()
+		<-c.done
+		return err
+	}
+}
+
+// Stats returns the delivery statistics of the dispatcher
+func (c *NotifyDispatcher) Stats() DispatcherStats {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	return c.stats
+}
+
+func (c *NotifyDispatcher) updateStats(update func(stats *DispatcherStats)) {
+	c.statsLock.Lock()
+	update(&c.stats)
+	c.statsLock.Unlock()
+}
+
+func (c *NotifyDispatcher) run() {
+	defer close(c.done)
+
+	pending := make([]notifyEntry, 0)
+	seen := make(map[notifyEntry]bool)
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	add := func(entry notifyEntry) {
+		if seen[entry] {
+			c.updateStats(func(stats *DispatcherStats) {
+				stats.Queued++
+				stats.Coalesced++
+			})
+			return
+		}
+		c.updateStats(func(stats *DispatcherStats) {
+			stats.Queued++
+		})
+		seen[entry] = true
+		pending = append(pending, entry)
+	}
+	sendPending := func() {
+		for len(pending) > 0 {
+			n := len(pending)
+			if n > maxNotificationEntries {
+				n = maxNotificationEntries
+			}
+			c.sendBatch(pending[:n])
+			pending = pending[n:]
+		}
+		pending = make([]notifyEntry, 0)
+		seen = make(map[notifyEntry]bool)
+	}
+	drain := func() {
+		for {
+			select {
+			case entry, ok := <-c.queue:
+				if !ok {
+					return
+				}
+				add(entry)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-c.queue:
+			if !ok {
+				sendPending()
+				return
+			}
+			add(entry)
+		case <-ticker.C:
+			sendPending()
+		case ack := <-c.flush:
+			drain()
+			sendPending()
+			close(ack)
+		}
+	}
+}
+
+// sendBatch sends a batch of at most 100 entries, retrying on temporary errors
+func (c *NotifyDispatcher) sendBatch(entries []notifyEntry) {
+	evt := Notification{}
+	for _, entry := range entries {
+		if entry.user {
+			evt.AddUser(entry.id)
+		} else {
+			evt.AddTrigger(entry.id)
+		}
+	}
+
+	backoff := c.opts.MinBackoff
+	for retry := 0; ; retry++ {
+		if !c.throttle() {
+			c.updateStats(func(stats *DispatcherStats) {
+				stats.Failed += uint64(len(entries))
+			})
+			return
+		}
+		err := c.send(c.ctx, evt)
+		if err == nil {
+			c.updateStats(func(stats *DispatcherStats) {
+				stats.Batches++
+				stats.Delivered += uint64(len(entries))
+			})
+			return
+		}
+
+		wait, retryable := backoff, true
+		var realtimeErr RealtimeError
+		if errors.As(err, &realtimeErr) {
+			retryable = realtimeErr.Temporary()
+			if realtimeErr.RetryAfter > 0 {
+				wait = realtimeErr.RetryAfter
+			}
+		}
+		if !retryable || retry >= c.opts.MaxRetries || c.ctx.Err() != nil {
+			c.updateStats(func(stats *DispatcherStats) {
+				stats.Failed += uint64(len(entries))
+			})
+			return
+		}
+
+		c.updateStats(func(stats *DispatcherStats) {
+			stats.Retries++
+		})
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			c.updateStats(func(stats *DispatcherStats) {
+				stats.Failed += uint64(len(entries))
+			})
+			return
+		}
+		if backoff *= 2; backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+// throttle waits until opts.RateLimit allows a request, returning false if the dispatcher was aborted before or meanwhile
+func (c *NotifyDispatcher) throttle() bool {
+	if c.ctx.Err() != nil {
+		return false
+	}
+	if c.opts.RateLimit.unlimited() {
+		return true
+	}
+	for {
+		ok, wait := c.bucket.take(c.opts.RateLimit, time.Now())
+		if ok {
+			return true
+		}
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			return false
+		}
+	}
+}
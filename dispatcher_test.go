@@ -0,0 +1,203 @@
+package ifttt
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type recordingSender struct {
+	lock    sync.Mutex
+	batches []Notification
+	errs    []error
+}
+
+func (c *recordingSender) send(ctx context.Context, evt Notification) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	c.batches = append(c.batches, evt)
+	return nil
+}
+
+func TestNotifyDispatcherBatching(t *testing.T) {
+	sender := &recordingSender{}
+	dispatcher := newNotifyDispatcher(sender.send, DispatcherOptions{
+		Interval: time.Hour,
+	})
+
+	for i := 0; i < 250; i++ {
+		dispatcher.NotifyUser(string(rune('a'+i%26)) + string(rune('a'+i/26)))
+	}
+	dispatcher.NotifyUser("aa")
+	dispatcher.NotifyTrigger("aa")
+	dispatcher.Flush()
+
+	if len(sender.batches) != 3 || sender.batches[0].len() != 100 || sender.batches[1].len() != 100 || sender.batches[2].len() != 51 {
+		t.Errorf("Unexpected batches: %v\n", sender.batches)
+		t.Fail()
+	}
+	if stats := dispatcher.Stats(); stats != (DispatcherStats{Queued: 252, Coalesced: 1, Batches: 3, Delivered: 251}) {
+		t.Errorf("Unexpected stats: %+v\n", stats)
+		t.Fail()
+	}
+
+	if err := dispatcher.Shutdown(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if err := dispatcher.NotifyUser("foo"); err != ErrorDispatcherClosed {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestNotifyDispatcherRetry(t *testing.T) {
+	sender := &recordingSender{
+		errs: []error{
+			RealtimeError{StatusCode: 503},
+			RealtimeError{StatusCode: 429, RetryAfter: time.Millisecond},
+		},
+	}
+	dispatcher := newNotifyDispatcher(sender.send, DispatcherOptions{
+		Interval:   time.Hour,
+		MinBackoff: time.Millisecond,
+	})
+
+	dispatcher.NotifyTrigger("foo")
+	dispatcher.Flush()
+	sender.lock.Lock()
+	sender.errs = []error{RealtimeError{StatusCode: 400}}
+	sender.lock.Unlock()
+	dispatcher.NotifyTrigger("bar")
+	dispatcher.Flush()
+
+	if len(sender.batches) != 1 || sender.batches[0].triggers[0] != "foo" {
+		t.Errorf("Unexpected batches: %v\n", sender.batches)
+		t.Fail()
+	}
+	if stats := dispatcher.Stats(); stats != (DispatcherStats{Queued: 2, Batches: 1, Delivered: 1, Retries: 2, Failed: 1}) {
+		t.Errorf("Unexpected stats: %+v\n", stats)
+		t.Fail()
+	}
+
+	dispatcher.NotifyTrigger("baz")
+	if err := dispatcher.Shutdown(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if len(sender.batches) != 2 {
+		t.Errorf("Pending notifications not sent on shutdown: %v\n", sender.batches)
+		t.Fail()
+	}
+}
+
+func TestNotifyDispatcherBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	sent := make(chan struct{}, 1)
+	dispatcher := newNotifyDispatcher(func(ctx context.Context, evt Notification) error {
+		sent <- struct{}{}
+		<-release
+		return nil
+	}, DispatcherOptions{
+		Interval:  time.Hour,
+		QueueSize: 1,
+	})
+
+	dispatcher.NotifyTrigger("foo")
+	go dispatcher.Flush()
+	<-sent
+	// the run loop is blocked sending, the queue fills up without blocking callers
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = dispatcher.NotifyTrigger("bar")
+	}
+	if err != ErrorDispatcherQueueFull || dispatcher.Stats().Rejected != 1 {
+		t.Errorf("Expected a full queue, got %v %+v\n", err, dispatcher.Stats())
+		t.Fail()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	if err := dispatcher.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected shutdown to honor its deadline, got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestNotifyDispatcherShutdownDeadline(t *testing.T) {
+	var sends int32
+	// the sender blocks until the request is canceled, as a client without a timeout would
+	dispatcher := newNotifyDispatcher(func(ctx context.Context, evt Notification) error {
+		atomic.AddInt32(&sends, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}, DispatcherOptions{
+		Interval:   time.Hour,
+		MinBackoff: time.Millisecond,
+	})
+	for i := 0; i < 1000; i++ {
+		dispatcher.NotifyUser(strconv.Itoa(i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := dispatcher.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected shutdown to honor its deadline, got %v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected shutdown to return within its deadline, took %v\n", elapsed)
+	}
+	if n := atomic.LoadInt32(&sends); n != 1 {
+		t.Errorf("Expected no batch to be sent after the deadline, got %d sends\n", n)
+	}
+	if stats := dispatcher.Stats(); stats.Failed != 1000 || stats.Delivered != 0 {
+		t.Errorf("Expected all entries to fail, got %+v\n", stats)
+	}
+}
+
+func TestNotifyDispatcherRateLimit(t *testing.T) {
+	sender := &recordingSender{}
+	dispatcher := newNotifyDispatcher(sender.send, DispatcherOptions{
+		Interval:  time.Hour,
+		RateLimit: RateLimit{Requests: 1, Per: 30 * time.Millisecond},
+	})
+	for i := 0; i < 250; i++ {
+		dispatcher.NotifyUser(string(rune('a'+i%26)) + string(rune('a'+i/26)))
+	}
+	start := time.Now()
+	dispatcher.Flush()
+	if elapsed := time.Since(start); len(sender.batches) != 3 || elapsed < 55*time.Millisecond {
+		t.Errorf("Expected 3 batches sent at the rate limit, got %d in %v\n", len(sender.batches), elapsed)
+		t.Fail()
+	}
+	dispatcher.Shutdown(context.Background())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if res := parseRetryAfter("120"); res != 2*time.Minute {
+		t.Errorf("Unexpected duration: %v\n", res)
+		t.Fail()
+	}
+	if res := parseRetryAfter(""); res != 0 {
+		t.Errorf("Unexpected duration: %v\n", res)
+		t.Fail()
+	}
+	if res := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); res <= 59*time.Minute {
+		t.Errorf("Unexpected duration: %v\n", res)
+		t.Fail()
+	}
+}
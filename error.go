@@ -2,6 +2,11 @@ package ifttt
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Jeffail/gabs"
 )
@@ -24,6 +29,154 @@ func (c AuthError) Error() string {
 	return "Token invalid"
 }
 
+// RealtimeError is returned by Service.Notify when the IFTTT realtime API responded with a non-200 status
+type RealtimeError struct {
+	// StatusCode the HTTP status code returned
+	StatusCode int
+	// Messages the error messages parsed from the response, empty if the response was not in the IFTTT error format
+	Messages []string
+	// RetryAfter the delay requested by the Retry-After header, 0 if not present
+	RetryAfter time.Duration
+	// Body the raw response body
+	Body string
+}
+
+func newRealtimeError(resp *http.Response, body []byte) RealtimeError {
+	res := RealtimeError{
+		StatusCode: resp.StatusCode,
+		Messages:   make([]string, 0),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Body:       string(body),
+	}
+	if decoded, err := gabs.ParseJSON(body); err == nil {
+		if errs, err := decoded.S("errors").Children(); err == nil {
+			for _, errObj := range errs {
+				if msg, ok := errObj.S("message").Data().(string); ok {
+					res.Messages = append(res.Messages, msg)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func (c RealtimeError) Error() string {
+	if len(c.Messages) > 0 {
+		return fmt.Sprintf("Remote returned code %d with: %s", c.StatusCode, strings.Join(c.Messages, "; "))
+	}
+	return fmt.Sprintf("Remote returned code %d with: %s", c.StatusCode, c.Body)
+}
+
+// Temporary returns whether the request could succeed if retried later
+func (c RealtimeError) Temporary() bool {
+	return c.StatusCode >= 500 || c.StatusCode == http.StatusTooManyRequests
+}
+
+// SkipError is returned by an action to have IFTTT skip it instead of reporting a failure
+// Returned from other handlers it is answered with 400.
+type SkipError struct {
+	// Message the explanation displayed to the user, defaults to the message of Err
+	Message string
+	// Err the underlying error, may be nil
+	Err error
+}
+
+func (c SkipError) Error() string {
+	if len(c.Message) > 0 {
+		return c.Message
+	}
+	if c.Err != nil {
+		return c.Err.Error()
+	}
+	return "Skipped"
+}
+
+// Unwrap returns the underlying error
+func (c SkipError) Unwrap() error {
+	return c.Err
+}
+
+// RetryableError is returned when the request failed temporarily and IFTTT should retry it later, it is answered with 503
+type RetryableError struct {
+	// Err the underlying error
+	Err error
+	// RetryAfter the delay sent in the Retry-After header, omitted if 0
+	RetryAfter time.Duration
+}
+
+func (c RetryableError) Error() string {
+	if c.Err != nil {
+		return c.Err.Error()
+	}
+	return "Service temporarily unavailable"
+}
+
+// Unwrap returns the underlying error
+func (c RetryableError) Unwrap() error {
+	return c.Err
+}
+
+// ValidationError is returned when the fields or other content of a request are invalid, it is answered with 400 and skips actions
+type ValidationError struct {
+	// Message the explanation displayed to the user
+	Message string
+	// Err the underlying error, may be nil
+	Err error
+}
+
+func (c ValidationError) Error() string {
+	if len(c.Message) > 0 {
+		return c.Message
+	}
+	if c.Err != nil {
+		return c.Err.Error()
+	}
+	return "Invalid request"
+}
+
+// Unwrap returns the underlying error
+func (c ValidationError) Unwrap() error {
+	return c.Err
+}
+
+// NotFoundError is returned when the resource requested does not exist, it is answered with 404 or skips actions
+type NotFoundError struct {
+	// Message the explanation displayed to the user, defaults to "Not Found"
+	Message string
+}
+
+func (c NotFoundError) Error() string {
+	if len(c.Message) > 0 {
+		return c.Message
+	}
+	return "Not Found"
+}
+
+// RateLimitedError is returned when the user or the service exceeded a rate limit, it is answered with 429
+type RateLimitedError struct {
+	// Message the explanation displayed to the user, defaults to "Rate limit exceeded"
+	Message string
+	// RetryAfter the delay sent in the Retry-After header, omitted if 0
+	RetryAfter time.Duration
+}
+
+func (c RateLimitedError) Error() string {
+	if len(c.Message) > 0 {
+		return c.Message
+	}
+	return "Rate limit exceeded"
+}
+
+// requestError is returned when IFTTT sent a request which could not be handled, Status is the HTTP status code of the response
+type requestError struct {
+	Status  int
+	Message string
+}
+
+func (c requestError) Error() string {
+	return c.Message
+}
+
 func marshalError(err error, skip bool) []byte {
 	data := gabs.New()
 	errObj := gabs.New()
@@ -35,3 +188,54 @@ func marshalError(err error, skip bool) []byte {
 	data.ArrayAppend(errObj.Data(), "errors")
 	return data.Bytes()
 }
+
+// retryAfterHeader formats d as the value of a Retry-After header, rounding up to whole seconds
+func retryAfterHeader(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// errorStatus maps err to the HTTP status, SKIP flag and Retry-After delay of its response
+// Errors from actions which are not recognized are answered with 400 like IFTTT expects, others with 500.
+func errorStatus(err error, action bool) (status int, skip bool, retryAfter time.Duration) {
+	var (
+		authErr       AuthError
+		skipErr       SkipError
+		retryableErr  RetryableError
+		validationErr ValidationError
+		fieldErrs     FieldErrors
+		fieldErr      FieldError
+		notFoundErr   NotFoundError
+		rateLimitErr  RateLimitedError
+		reqErr        requestError
+	)
+	switch {
+	case errors.As(err, &authErr):
+		return http.StatusUnauthorized, false, 0
+	case errors.As(err, &rateLimitErr):
+		return http.StatusTooManyRequests, false, rateLimitErr.RetryAfter
+	case errors.As(err, &retryableErr):
+		return http.StatusServiceUnavailable, false, retryableErr.RetryAfter
+	case errors.As(err, &skipErr), errors.As(err, &validationErr), errors.As(err, &fieldErrs), errors.As(err, &fieldErr):
+		return http.StatusBadRequest, action, 0
+	case errors.As(err, &notFoundErr):
+		if action {
+			return http.StatusBadRequest, true, 0
+		}
+		return http.StatusNotFound, false, 0
+	case errors.As(err, &reqErr):
+		return reqErr.Status, false, 0
+	case action:
+		return http.StatusBadRequest, false, 0
+	}
+	return http.StatusInternalServerError, false, 0
+}
+
+// writeError writes the response of err, skip forces the SKIP flag on action errors
+func writeError(w http.ResponseWriter, err error, action bool, skip bool) {
+	status, errSkip, retryAfter := errorStatus(err, action)
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterHeader(retryAfter))
+	}
+	w.WriteHeader(status)
+	w.Write(marshalError(err, errSkip || (action && skip && status == http.StatusBadRequest)))
+}
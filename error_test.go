@@ -2,7 +2,10 @@ package ifttt
 
 import (
 	"errors"
+	"fmt"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestMarshalError(t *testing.T) {
@@ -41,3 +44,35 @@ func TestAuthError(t *testing.T) {
 	}
 
 }
+
+func TestWriteError(t *testing.T) {
+	for _, c := range []struct {
+		err        error
+		action     bool
+		skip       bool
+		code       int
+		body       string
+		retryAfter string
+	}{
+		{AuthError{}, true, true, 401, `{"errors":[{"message":"Token invalid"}]}`, ""},
+		{SkipError{Message: "Nothing to do"}, true, false, 400, `{"errors":[{"message":"Nothing to do","status":"SKIP"}]}`, ""},
+		{SkipError{Err: errors.New("Nothing to do")}, false, false, 400, `{"errors":[{"message":"Nothing to do"}]}`, ""},
+		{ValidationError{Message: "Bad title"}, true, false, 400, `{"errors":[{"message":"Bad title","status":"SKIP"}]}`, ""},
+		{NotFoundError{}, true, false, 400, `{"errors":[{"message":"Not Found","status":"SKIP"}]}`, ""},
+		{NotFoundError{"No such album"}, false, false, 404, `{"errors":[{"message":"No such album"}]}`, ""},
+		{RateLimitedError{RetryAfter: 1500 * time.Millisecond}, true, true, 429, `{"errors":[{"message":"Rate limit exceeded"}]}`, "2"},
+		{RetryableError{Err: errors.New("Upstream down"), RetryAfter: time.Minute}, false, false, 503, `{"errors":[{"message":"Upstream down"}]}`, "60"},
+		{fmt.Errorf("Wrapped: %w", AuthError{"Expired"}), false, false, 401, `{"errors":[{"message":"Wrapped: Expired"}]}`, ""},
+		{fmt.Errorf("Wrapped: %w", SkipError{}), true, false, 400, `{"errors":[{"message":"Wrapped: Skipped","status":"SKIP"}]}`, ""},
+		{errors.New("Failed"), true, true, 400, `{"errors":[{"message":"Failed","status":"SKIP"}]}`, ""},
+		{errors.New("Failed"), true, false, 400, `{"errors":[{"message":"Failed"}]}`, ""},
+		{errors.New("Failed"), false, false, 500, `{"errors":[{"message":"Failed"}]}`, ""},
+	} {
+		res := httptest.NewRecorder()
+		writeError(res, c.err, c.action, c.skip)
+		if res.Code != c.code || !jsonEqual(res.Body.Bytes(), []byte(c.body)) || res.Header().Get("Retry-After") != c.retryAfter {
+			t.Errorf("Unexpected response of %#v: %d %s %q\n", c.err, res.Code, res.Body.String(), res.Header().Get("Retry-After"))
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package ifttt
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventRetention configures how many events an EventStore keeps for each key
+type EventRetention struct {
+	// MaxCount the max number of events kept for each key, 0 for unlimited
+	MaxCount int
+	// MaxAge the max age of events kept measured by TriggerEventMeta.Time, 0 for unlimited
+	MaxAge time.Duration
+}
+
+// EventStore keeps the event history of triggers which implement StoredTrigger.
+// Events are grouped by keys, use IdentityEventKey or UserEventKey to build one.
+type EventStore interface {
+	// Push adds events to the history of key, events with the same Meta.ID as a previous event replace it
+	Push(key string, events ...TriggerEvent) error
+	// Events returns at most limit events of key, newest first
+	Events(key string, limit int) (TriggerEventCollection, error)
+	// Remove drops the history of key
+	Remove(key string) error
+}
+
+// StoredTrigger can be optionally implemented by a Trigger to have its polls answered from Service.Events instead of calling Poll.
+// New events should be added by Service.PushEvents under the key returned by EventKey.
+type StoredTrigger interface {
+	// EventKey returns the key under which the events of the polled trigger were pushed
+	EventKey(req *TriggerPollRequest, r *Request) (string, error)
+}
+
+// IdentityEventKey returns the event key of events pushed to a single trigger identity
+func IdentityEventKey(identity string) string {
+	return "identity:" + identity
+}
+
+// UserEventKey returns the event key of events pushed to all triggers of a user
+func UserEventKey(userid string) string {
+	return "user:" + userid
+}
+
+// MemoryEventStore is an EventStore which keeps events in memory
+type MemoryEventStore struct {
+	retention EventRetention
+	lock      sync.Mutex
+	events    map[string]TriggerEventCollection
+}
+
+// NewMemoryEventStore creates an empty MemoryEventStore with the retention policy
+func NewMemoryEventStore(retention EventRetention) *MemoryEventStore {
+	return &MemoryEventStore{
+		retention: retention,
+		events:    make(map[string]TriggerEventCollection),
+	}
+}
+
+// expire drops events outside the retention policy, evts must be sorted
+func (c *MemoryEventStore) expire(evts TriggerEventCollection) TriggerEventCollection {
+	if c.retention.MaxAge > 0 {
+		deadline := time.Now().Add(-c.retention.MaxAge)
+		for i, evt := range evts {
+			if evt.Meta.Time.Before(deadline) {
+				evts = evts[:i]
+				break
+			}
+		}
+	}
+	if c.retention.MaxCount > 0 && len(evts) > c.retention.MaxCount {
+		evts = evts[:c.retention.MaxCount]
+	}
+	return evts
+}
+
+// Push implements EventStore
+func (c *MemoryEventStore) Push(key string, events ...TriggerEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	evts := c.events[key]
+	for _, evt := range events {
+		replaced := false
+		for i := range evts {
+			if evts[i].Meta.ID == evt.Meta.ID {
+				evts[i] = evt
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			evts = append(evts, evt)
+		}
+	}
+	sort.Stable(evts)
+	c.events[key] = c.expire(evts)
+	return nil
+}
+
+// Events implements EventStore
+func (c *MemoryEventStore) Events(key string, limit int) (TriggerEventCollection, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	evts, ok := c.events[key]
+	if !ok {
+		return TriggerEventCollection{}, nil
+	}
+	evts = c.expire(evts)
+	c.events[key] = evts
+	if limit >= 0 && limit < len(evts) {
+		evts = evts[:limit]
+	}
+	res := make(TriggerEventCollection, len(evts))
+	copy(res, evts)
+	return res, nil
+}
+
+// Remove implements EventStore
+func (c *MemoryEventStore) Remove(key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.events, key)
+	return nil
+}
@@ -0,0 +1,70 @@
+package ifttt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryEventStore(t *testing.T) {
+	store := NewMemoryEventStore(EventRetention{
+		MaxCount: 3,
+		MaxAge:   time.Hour,
+	})
+	now := time.Now()
+	event := func(id string, age time.Duration) TriggerEvent {
+		return TriggerEvent{
+			Ingredients: map[string]string{"id": id},
+			Meta: TriggerEventMeta{
+				ID:   id,
+				Time: now.Add(-age),
+			},
+		}
+	}
+	ids := func(evts TriggerEventCollection) []string {
+		res := make([]string, 0)
+		for _, evt := range evts {
+			res = append(res, evt.Meta.ID)
+		}
+		return res
+	}
+
+	store.Push(IdentityEventKey("foo"), event("1", 3*time.Minute), event("2", 2*time.Minute))
+	store.Push(IdentityEventKey("foo"), event("3", time.Minute), event("1", 30*time.Second))
+	if res, err := store.Events(IdentityEventKey("foo"), 50); err != nil || !stringSliceEqual(ids(res), []string{"1", "3", "2"}) {
+		t.Errorf("Unexpected events: %v %v\n", ids(res), err)
+		t.Fail()
+	}
+	if res, err := store.Events(IdentityEventKey("foo"), 2); err != nil || !stringSliceEqual(ids(res), []string{"1", "3"}) {
+		t.Errorf("Unexpected events: %v %v\n", ids(res), err)
+		t.Fail()
+	}
+
+	store.Push(IdentityEventKey("foo"), event("4", 0), event("5", 2*time.Hour))
+	if res, err := store.Events(IdentityEventKey("foo"), 50); err != nil || !stringSliceEqual(ids(res), []string{"4", "1", "3"}) {
+		t.Errorf("Unexpected events: %v %v\n", ids(res), err)
+		t.Fail()
+	}
+
+	if res, err := store.Events(UserEventKey("foo"), 50); err != nil || len(res) != 0 {
+		t.Errorf("Unexpected events: %v %v\n", ids(res), err)
+		t.Fail()
+	}
+
+	store.Remove(IdentityEventKey("foo"))
+	if res, err := store.Events(IdentityEventKey("foo"), 50); err != nil || len(res) != 0 {
+		t.Errorf("Unexpected events: %v %v\n", ids(res), err)
+		t.Fail()
+	}
+}
+
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
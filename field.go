@@ -0,0 +1,316 @@
+package ifttt
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+// FieldType enumerates the types of trigger, action and query fields
+// https://platform.ifttt.com/docs/api_reference#field-types
+type FieldType int
+
+const (
+	// TextField a text field, decoded into string
+	TextField FieldType = iota
+	// NumberField a number field, decoded into float64
+	NumberField
+	// BooleanField a boolean field, decoded into bool
+	BooleanField
+	// DateTimeField a date and time field in ISO 8601 format, decoded into time.Time
+	DateTimeField
+	// LocationField a location field, decoded into Location
+	LocationField
+	// OptionField a drop-down list field, decoded into string
+	OptionField
+)
+
+// String implements fmt.Stringer
+func (c FieldType) String() string {
+	switch c {
+	case TextField:
+		return "text"
+	case NumberField:
+		return "number"
+	case BooleanField:
+		return "boolean"
+	case DateTimeField:
+		return "datetime"
+	case LocationField:
+		return "location"
+	case OptionField:
+		return "option"
+	}
+	return "unknown"
+}
+
+// FieldSpec declares the type and constraints of a single field
+type FieldSpec struct {
+	// Type the type of the field
+	Type FieldType
+	// Required whether the field must be present and not empty
+	Required bool
+	// MaxLength the max length of a text field, 0 for unlimited
+	MaxLength int
+	// Pattern the regular expression a text field must match, ignored if nil
+	Pattern *regexp.Regexp
+	// Min the min value of a number field, ignored if nil
+	Min *float64
+	// Max the max value of a number field, ignored if nil
+	Max *float64
+	// Options the accepted values of an option field, any value is accepted if empty (eg: dynamic options)
+	Options []string
+}
+
+// FieldSchema declares the fields of a trigger, action or query, keyed by field slug
+type FieldSchema map[string]FieldSpec
+
+// SchemaProvider can be optionally implemented by a Trigger, Action or Query to declare its fields.
+// Fields of requests to these handlers are decoded according to the schema before the handler is called,
+// requests with fields which do not satisfy the schema are refused, and single-field validation requests are checked against the schema before ValidateField is called.
+type SchemaProvider interface {
+	// FieldSchema returns the schema of the fields
+	FieldSchema() FieldSchema
+}
+
+// FieldError describes a field which does not satisfy its FieldSpec
+type FieldError struct {
+	// Field the field slug
+	Field string
+	// Message a description of the problem
+	Message string
+}
+
+func (c FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", c.Field, c.Message)
+}
+
+// FieldErrors is returned when one or more fields do not satisfy the schema
+type FieldErrors []FieldError
+
+func (c FieldErrors) Error() string {
+	msgs := make([]string, len(c))
+	for i, err := range c {
+		msgs[i] = err.Error()
+	}
+	return "Invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// FieldValues contains the decoded values of fields, keyed by field slug
+// Values of fields declared in a FieldSchema have the type of their FieldType, others are left as decoded from JSON.
+type FieldValues map[string]interface{}
+
+// String returns the value of a text or option field, empty string if absent or of another type
+func (c FieldValues) String(slug string) string {
+	res, _ := c[slug].(string)
+	return res
+}
+
+// Number returns the value of a number field, 0 if absent or of another type
+func (c FieldValues) Number(slug string) float64 {
+	res, _ := c[slug].(float64)
+	return res
+}
+
+// Bool returns the value of a boolean field, false if absent or of another type
+func (c FieldValues) Bool(slug string) bool {
+	res, _ := c[slug].(bool)
+	return res
+}
+
+// Time returns the value of a datetime field, zero time if absent or of another type
+func (c FieldValues) Time(slug string) time.Time {
+	res, _ := c[slug].(time.Time)
+	return res
+}
+
+// dateTimeLayouts are the layouts accepted in datetime fields
+var dateTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseDateTime parses the value of a datetime field, values without a UTC offset are read in UTC
+func parseDateTime(value string) (time.Time, error) {
+	return parseDateTimeIn(value, time.UTC)
+}
+
+// parseDateTimeIn parses the value of a datetime field, values without a UTC offset are read in loc
+func parseDateTimeIn(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range dateTimeLayouts {
+		if res, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return res, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a valid date and time", value)
+}
+
+// fieldString returns the string form of a raw field value, non-string values are encoded in JSON
+func fieldString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	res, _ := json.Marshal(val)
+	return string(res)
+}
+
+// isEmpty returns whether a raw field value is considered missing
+func isEmpty(val interface{}) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	}
+	return false
+}
+
+// decode converts a raw field value into the type of the spec and checks the constraints, datetime values without a UTC offset are read in loc
+func (c FieldSpec) decode(val interface{}, loc *time.Location) (interface{}, error) {
+	switch c.Type {
+	case TextField, OptionField:
+		str := fieldString(val)
+		if c.MaxLength > 0 && len([]rune(str)) > c.MaxLength {
+			return nil, fmt.Errorf("must not be longer than %d characters", c.MaxLength)
+		}
+		if c.Pattern != nil && !c.Pattern.MatchString(str) {
+			return nil, fmt.Errorf("%q is not in a valid format", str)
+		}
+		if c.Type == OptionField && len(c.Options) > 0 {
+			for _, option := range c.Options {
+				if option == str {
+					return str, nil
+				}
+			}
+			return nil, fmt.Errorf("%q is not a valid option", str)
+		}
+		return str, nil
+	case NumberField:
+		var num float64
+		switch v := val.(type) {
+		case float64:
+			num = v
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", v)
+			}
+			num = parsed
+		default:
+			return nil, fmt.Errorf("%s is not a number", fieldString(val))
+		}
+		if c.Min != nil && num < *c.Min {
+			return nil, fmt.Errorf("must not be less than %v", *c.Min)
+		}
+		if c.Max != nil && num > *c.Max {
+			return nil, fmt.Errorf("must not be greater than %v", *c.Max)
+		}
+		return num, nil
+	case BooleanField:
+		switch v := val.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return parsed, nil
+			}
+		}
+		return nil, fmt.Errorf("%s is not a boolean", fieldString(val))
+	case DateTimeField:
+		if str, ok := val.(string); ok {
+			return parseDateTimeIn(str, loc)
+		}
+		return nil, fmt.Errorf("%s is not a valid date and time", fieldString(val))
+	case LocationField:
+		return parseLocation(val)
+	}
+	return nil, fmt.Errorf("unknown field type %d", c.Type)
+}
+
+// Validate checks a single raw field value against the spec
+func (c FieldSpec) Validate(val interface{}) error {
+	if isEmpty(val) {
+		if c.Required {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+	_, err := c.decode(val, time.UTC)
+	return err
+}
+
+// Decode converts raw field values into FieldValues, returning FieldErrors describing every field which does not satisfy the schema
+// Datetime values without a UTC offset are read in UTC.
+func (c FieldSchema) Decode(raw map[string]interface{}) (FieldValues, error) {
+	return c.DecodeInLocation(raw, time.UTC)
+}
+
+// DecodeInLocation is like Decode but reads datetime values without a UTC offset in loc
+func (c FieldSchema) DecodeInLocation(raw map[string]interface{}, loc *time.Location) (FieldValues, error) {
+	res := make(FieldValues)
+	errs := make(FieldErrors, 0)
+	for key, val := range raw {
+		res[key] = val
+	}
+	for key, spec := range c {
+		val := raw[key]
+		if isEmpty(val) {
+			if spec.Required {
+				errs = append(errs, FieldError{key, "is required"})
+			}
+			continue
+		}
+		decoded, err := spec.decode(val, loc)
+		if err != nil {
+			errs = append(errs, FieldError{key, err.Error()})
+			continue
+		}
+		res[key] = decoded
+	}
+	if len(errs) > 0 {
+		return res, errs
+	}
+	return res, nil
+}
+
+// decodeFields reads the fields object at key of body into its string form and FieldValues, decoded by the schema of handler if it implements SchemaProvider
+// Datetime values without a UTC offset are read in loc.
+func decodeFields(body *gabs.Container, key string, handler interface{}, loc *time.Location) (map[string]string, FieldValues, error) {
+	fields, err := body.S(key).ChildrenMap()
+	if err != nil {
+		return nil, nil, err
+	}
+	strs := make(map[string]string)
+	raw := make(map[string]interface{})
+	for slug, val := range fields {
+		strs[slug] = fieldString(val.Data())
+		raw[slug] = val.Data()
+	}
+	if provider, ok := unwrapHandler(handler).(SchemaProvider); ok {
+		values, err := provider.FieldSchema().DecodeInLocation(raw, loc)
+		return strs, values, err
+	}
+	return strs, FieldValues(raw), nil
+}
+
+// validateFieldSchema checks the value of a single-field validation request against the schema of handler, if it implements SchemaProvider
+func validateFieldSchema(handler interface{}, fieldslug string, value interface{}) error {
+	if provider, ok := unwrapHandler(handler).(SchemaProvider); ok {
+		if spec, ok := provider.FieldSchema()[fieldslug]; ok {
+			if err := spec.Validate(value); err != nil {
+				return FieldError{fieldslug, err.Error()}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package ifttt
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFieldSchemaDecode(t *testing.T) {
+	min := 0.0
+	schema := FieldSchema{
+		"title":   {Type: TextField, Required: true, MaxLength: 10},
+		"code":    {Type: TextField, Pattern: regexp.MustCompile("^[0-9]+$")},
+		"count":   {Type: NumberField, Min: &min},
+		"enabled": {Type: BooleanField},
+		"due":     {Type: DateTimeField},
+		"place":   {Type: LocationField},
+		"color":   {Type: OptionField, Options: []string{"red", "blue"}},
+	}
+
+	values, err := schema.Decode(map[string]interface{}{
+		"title":   "Hello",
+		"code":    "123",
+		"count":   "42",
+		"enabled": true,
+		"due":     "2019-01-02T15:04:05Z",
+		"place":   map[string]interface{}{"lat": 1.0, "lng": 2.0},
+		"color":   "red",
+		"extra":   "foo",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if values.String("title") != "Hello" || values.Number("count") != 42 || !values.Bool("enabled") || values.String("color") != "red" || values.String("extra") != "foo" {
+		t.Errorf("Unexpected values: %v\n", values)
+		t.Fail()
+	}
+	if loc, err := values.Location("place"); err != nil || loc.Lat != 1 || loc.Lng != 2 {
+		t.Errorf("Unexpected location: %v %v\n", loc, err)
+		t.Fail()
+	}
+	if !values.Time("due").Equal(time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("Unexpected time: %v\n", values.Time("due"))
+		t.Fail()
+	}
+
+	_, err = schema.Decode(map[string]interface{}{
+		"code":    "abc",
+		"count":   -1.0,
+		"enabled": "maybe",
+		"due":     "yesterday",
+		"place":   "home",
+		"color":   "green",
+	})
+	errs, ok := err.(FieldErrors)
+	if !ok || len(errs) != 7 {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestFieldSpecValidate(t *testing.T) {
+	spec := FieldSpec{Type: NumberField, Required: true}
+	if err := spec.Validate("12.5"); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if err := spec.Validate(""); err == nil {
+		t.Errorf("Missing required field accepted")
+		t.Fail()
+	}
+	if err := spec.Validate("abc"); err == nil {
+		t.Errorf("Invalid number accepted")
+		t.Fail()
+	}
+}
+
+func TestFieldString(t *testing.T) {
+	if res := fieldString(12.5); res != "12.5" {
+		t.Errorf("Unexpected string: %s\n", res)
+		t.Fail()
+	}
+	if res := fieldString(map[string]interface{}{"lat": 1}); res != `{"lat":1}` {
+		t.Errorf("Unexpected string: %s\n", res)
+		t.Fail()
+	}
+	if res := fieldString(nil); res != "" {
+		t.Errorf("Unexpected string: %s\n", res)
+		t.Fail()
+	}
+}
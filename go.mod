@@ -0,0 +1,10 @@
+module github.com/eternal-flame-AD/ifttt
+
+go 1.13
+
+require (
+	github.com/Jeffail/gabs v1.4.0
+	github.com/satori/go.uuid v1.2.1-0.20181028125025-b2ce2384e17b
+	github.com/smartystreets/goconvey v1.6.4
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
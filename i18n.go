@@ -0,0 +1,174 @@
+package ifttt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale messages fall back to when they are not available in the locale of the user
+const DefaultLocale = "en"
+
+// LocalizedError is an error whose message is rendered from Service.Catalog in the locale of the user
+// It can be returned by any handler method, directly or wrapped in another error (eg: SkipError{Err: Localize("album_missing", name)}),
+// in which case the localized message replaces the message of the whole error in the response.
+type LocalizedError struct {
+	// Key the message key looked up in the catalog
+	Key string
+	// Args the parameters of the message, formatted by the fmt verbs of the message (eg: %s, %[2]d)
+	Args []interface{}
+	// Err the underlying error, may be nil
+	Err error
+}
+
+// Localize creates a LocalizedError with the message key and parameters
+func Localize(key string, args ...interface{}) LocalizedError {
+	return LocalizedError{Key: key, Args: args}
+}
+
+// Error returns the message key and parameters, the message is only rendered when responding to IFTTT
+func (c LocalizedError) Error() string {
+	res := c.Key
+	if len(c.Args) > 0 {
+		res += fmt.Sprint(c.Args)
+	}
+	if c.Err != nil {
+		res += ": " + c.Err.Error()
+	}
+	return res
+}
+
+// Unwrap returns the underlying error
+func (c LocalizedError) Unwrap() error {
+	return c.Err
+}
+
+// Catalog provides the messages of LocalizedError keys
+type Catalog interface {
+	// Message returns the message of key in locale (eg: "en", "pt-BR"), ok is false if it is not available
+	Message(locale string, key string) (msg string, ok bool)
+}
+
+// MapCatalog is a Catalog keeping messages in memory, keyed by locale then message key
+type MapCatalog map[string]map[string]string
+
+// Message implements Catalog
+func (c MapCatalog) Message(locale string, key string) (string, bool) {
+	msg, ok := c[locale][key]
+	return msg, ok
+}
+
+// localizedMessage replaces the message of Err with a rendered message
+type localizedMessage struct {
+	Err     error
+	Message string
+}
+
+func (c localizedMessage) Error() string {
+	return c.Message
+}
+
+// Unwrap returns the original error so its type is still recognized
+func (c localizedMessage) Unwrap() error {
+	return c.Err
+}
+
+// normalizeLocale converts a language tag into the form used by catalogs (eg: "pt_br" to "pt-BR")
+func normalizeLocale(tag string) string {
+	parts := strings.Split(strings.Replace(strings.TrimSpace(tag), "_", "-", -1), "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// parseAcceptLanguage returns the languages of an Accept-Language header ordered by preference
+func parseAcceptLanguage(header string) []string {
+	type language struct {
+		tag string
+		q   float64
+	}
+	langs := make([]language, 0)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, language{normalizeLocale(tag), q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	res := make([]string, len(langs))
+	for i, lang := range langs {
+		res[i] = lang.tag
+	}
+	return res
+}
+
+// locales returns the candidate locales of req in order of preference: the locale in the user metadata, the Accept-Language header, then DefaultLocale
+// Each locale with a region is followed by its base language (eg: "pt-BR", "pt").
+func (c *Service) locales(req *Request) []string {
+	candidates := make([]string, 0)
+	if req != nil {
+		if req.DecodedBody != nil {
+			for _, key := range []string{"locale", "language"} {
+				if locale, ok := req.DecodedBody.Path("user." + key).Data().(string); ok && locale != "" {
+					candidates = append(candidates, normalizeLocale(locale))
+				}
+			}
+		}
+		if req.RawRequest != nil {
+			candidates = append(candidates, parseAcceptLanguage(req.RawRequest.Header.Get("Accept-Language"))...)
+		}
+	}
+	candidates = append(candidates, DefaultLocale)
+
+	res := make([]string, 0, len(candidates))
+	seen := make(map[string]bool)
+	add := func(locale string) {
+		if !seen[locale] {
+			seen[locale] = true
+			res = append(res, locale)
+		}
+	}
+	for _, locale := range candidates {
+		add(locale)
+		if i := strings.Index(locale, "-"); i > 0 {
+			add(locale[:i])
+		}
+	}
+	return res
+}
+
+// localize renders the LocalizedError wrapped in err in the locale of the user
+// err is returned unchanged if it does not wrap a LocalizedError or its key is not found in the catalog.
+func (c *Service) localize(req *Request, err error) error {
+	var localized LocalizedError
+	if err == nil || c.Catalog == nil || !errors.As(err, &localized) {
+		return err
+	}
+	for _, locale := range c.locales(req) {
+		if msg, ok := c.Catalog.Message(locale, localized.Key); ok {
+			return localizedMessage{err, fmt.Sprintf(msg, localized.Args...)}
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package ifttt
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+type testLocalizedAction struct{}
+
+func (c testLocalizedAction) Options(req *Request) (*DynamicOption, error) {
+	return nil, nil
+}
+
+func (c testLocalizedAction) Handle(r *ActionHandleRequest, req *Request) (*ActionResult, bool, error) {
+	return nil, false, SkipError{Err: Localize("album_missing", r.ActionFields["album"])}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	res := parseAcceptLanguage("fr;q=0.5, pt_br, *;q=0.1, de;q=0")
+	if !stringSliceEqual(res, []string{"pt-BR", "fr"}) {
+		t.Errorf("Unexpected languages: %v\n", res)
+		t.Fail()
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	service := &Service{
+		ServiceKey: "servicekey",
+		Catalog: MapCatalog{
+			"en": {"album_missing": "Album %s does not exist", "generic": "Something went wrong"},
+			"pt": {"album_missing": "O álbum %s não existe"},
+			"fr": {"album_missing": "L'album %s n'existe pas"},
+		},
+	}
+	service.RegisterAction("localized", testLocalizedAction{})
+
+	for _, c := range []struct {
+		language string
+		user     string
+		msg      string
+	}{
+		{"", "", "Album Street Art does not exist"},
+		{"de-DE", "", "Album Street Art does not exist"},
+		{"pt-BR,en;q=0.5", "", "O álbum Street Art não existe"},
+		{"pt-BR", `"locale":"fr_FR"`, "L'album Street Art n'existe pas"},
+	} {
+		req := httptest.NewRequest("POST", "/ifttt/v1/actions/localized", bytes.NewBufferString(`{"actionFields":{"album":"Street Art"},"user":{`+c.user+`}}`))
+		req.Header.Set("IFTTT-Service-Key", "servicekey")
+		if c.language != "" {
+			req.Header.Set("Accept-Language", c.language)
+		}
+		res := httptest.NewRecorder()
+		service.ServeHTTP(res, req)
+		if res.Code != 400 || !jsonEqual(res.Body.Bytes(), marshalError(errors.New(c.msg), true)) {
+			t.Errorf("Unexpected response with %q %q: %d %s\n", c.language, c.user, res.Code, res.Body.String())
+			t.Fail()
+		}
+	}
+
+	if err := service.localize(nil, Localize("generic")); err.Error() != "Something went wrong" {
+		t.Errorf("Unexpected fallback message: %v\n", err)
+		t.Fail()
+	}
+	if err := service.localize(nil, Localize("unknown", 1)); err.Error() != "unknown[1]" {
+		t.Errorf("Unexpected message of unknown key: %v\n", err)
+		t.Fail()
+	}
+	wrapped := service.localize(nil, RateLimitedError{Message: "ignored"})
+	if _, ok := wrapped.(RateLimitedError); !ok {
+		t.Errorf("Expected errors without LocalizedError to be unchanged: %#v\n", wrapped)
+		t.Fail()
+	}
+	wrapped = service.localize(nil, NotFoundError{})
+	if errors.As(wrapped, &LocalizedError{}) {
+		t.Errorf("Unexpected LocalizedError\n")
+		t.Fail()
+	}
+	if status, _, _ := errorStatus(service.localize(nil, SkipError{Err: Localize("generic")}), false); status != 400 {
+		t.Errorf("Expected localized errors to keep their status, got %d\n", status)
+		t.Fail()
+	}
+}
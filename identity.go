@@ -0,0 +1,213 @@
+package ifttt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TriggerIdentity describes an active trigger identity recorded in the identity registry
+type TriggerIdentity struct {
+	// Identity the trigger identity provided by IFTTT
+	Identity string `json:"identity"`
+	// Slug the slug of the trigger
+	Slug string `json:"slug"`
+	// TriggerFields the values of the trigger fields seen in the latest poll
+	TriggerFields map[string]string `json:"trigger_fields"`
+	// User contains the metadata of the IFTTT user seen in the latest poll (eg: timezone)
+	User map[string]string `json:"user"`
+	// UserID the id of the user owning this trigger as returned by Service.IdentityUserID, empty string if not available
+	UserID string `json:"user_id"`
+	// Created the time this identity was first seen
+	Created time.Time `json:"created"`
+}
+
+// IdentityStore is the storage backend of the trigger identity registry.
+// Identities are recorded by the Service on each trigger poll and removed when IFTTT notifies the trigger has been deleted.
+type IdentityStore interface {
+	// Put records an identity, replacing the previous record with the same Identity
+	Put(identity TriggerIdentity) error
+	// Get returns the identity recorded as identity, or nil if it was not recorded
+	Get(identity string) (*TriggerIdentity, error)
+	// Remove removes an identity, removing an identity which was not recorded is not an error
+	Remove(identity string) error
+	// BySlug returns all identities recorded for the trigger slug
+	BySlug(slug string) ([]TriggerIdentity, error)
+	// ByUser returns all identities recorded for the user id
+	ByUser(userid string) ([]TriggerIdentity, error)
+}
+
+// MemoryIdentityStore is an IdentityStore which keeps identities in memory
+type MemoryIdentityStore struct {
+	lock       sync.RWMutex
+	identities map[string]TriggerIdentity
+}
+
+// NewMemoryIdentityStore creates an empty MemoryIdentityStore
+func NewMemoryIdentityStore() *MemoryIdentityStore {
+	return &MemoryIdentityStore{
+		identities: make(map[string]TriggerIdentity),
+	}
+}
+
+// put records identity and returns whether the store was changed
+func (c *MemoryIdentityStore) put(identity TriggerIdentity) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if old, ok := c.identities[identity.Identity]; ok {
+		identity.Created = old.Created
+		if reflect.DeepEqual(old, identity) {
+			return false
+		}
+	} else if identity.Created.IsZero() {
+		identity.Created = time.Now()
+	}
+	c.identities[identity.Identity] = identity
+	return true
+}
+
+// Put implements IdentityStore
+func (c *MemoryIdentityStore) Put(identity TriggerIdentity) error {
+	c.put(identity)
+	return nil
+}
+
+// Get implements IdentityStore
+func (c *MemoryIdentityStore) Get(identity string) (*TriggerIdentity, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if res, ok := c.identities[identity]; ok {
+		return &res, nil
+	}
+	return nil, nil
+}
+
+// remove removes identity and returns whether the store was changed
+func (c *MemoryIdentityStore) remove(identity string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.identities[identity]; !ok {
+		return false
+	}
+	delete(c.identities, identity)
+	return true
+}
+
+// Remove implements IdentityStore
+func (c *MemoryIdentityStore) Remove(identity string) error {
+	c.remove(identity)
+	return nil
+}
+
+func (c *MemoryIdentityStore) filter(match func(identity *TriggerIdentity) bool) []TriggerIdentity {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := make([]TriggerIdentity, 0)
+	for _, identity := range c.identities {
+		if match(&identity) {
+			res = append(res, identity)
+		}
+	}
+	return res
+}
+
+// BySlug implements IdentityStore
+func (c *MemoryIdentityStore) BySlug(slug string) ([]TriggerIdentity, error) {
+	return c.filter(func(identity *TriggerIdentity) bool {
+		return identity.Slug == slug
+	}), nil
+}
+
+// ByUser implements IdentityStore
+func (c *MemoryIdentityStore) ByUser(userid string) ([]TriggerIdentity, error) {
+	return c.filter(func(identity *TriggerIdentity) bool {
+		return identity.UserID == userid
+	}), nil
+}
+
+// FileIdentityStore is an IdentityStore which keeps identities in memory and persists them to a JSON file on every change
+type FileIdentityStore struct {
+	MemoryIdentityStore
+	path      string
+	writeLock sync.Mutex
+	// dirty is set while the identities in memory have not been saved, eg: after a failed save
+	dirty bool
+}
+
+// NewFileIdentityStore creates a FileIdentityStore persisted at path, loading previously recorded identities if the file exists
+func NewFileIdentityStore(path string) (*FileIdentityStore, error) {
+	res := &FileIdentityStore{
+		MemoryIdentityStore: MemoryIdentityStore{
+			identities: make(map[string]TriggerIdentity),
+		},
+		path: path,
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return res, nil
+	} else if err != nil {
+		return nil, err
+	}
+	identities := make([]TriggerIdentity, 0)
+	if err := json.Unmarshal(data, &identities); err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		res.identities[identity.Identity] = identity
+	}
+	return res, nil
+}
+
+// Put implements IdentityStore
+func (c *FileIdentityStore) Put(identity TriggerIdentity) error {
+	return c.persist(c.put(identity))
+}
+
+// Remove implements IdentityStore
+func (c *FileIdentityStore) Remove(identity string) error {
+	return c.persist(c.remove(identity))
+}
+
+// persist saves the identities if they changed or an earlier save failed, so retries return the error until a save succeeds
+func (c *FileIdentityStore) persist(changed bool) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if !changed && !c.dirty {
+		return nil
+	}
+	c.dirty = true
+	if err := c.save(); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// save writes all identities to a temporary file and renames it over the store file, c.writeLock must be held
+func (c *FileIdentityStore) save() error {
+
+	data, err := json.Marshal(c.filter(func(identity *TriggerIdentity) bool {
+		return true
+	}))
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,108 @@
+package ifttt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testIdentityStore(t *testing.T, store IdentityStore) {
+	store.Put(TriggerIdentity{
+		Identity:      "92429d82a41e93048",
+		Slug:          "test_trigger",
+		TriggerFields: map[string]string{"foo": "bar"},
+		UserID:        "1",
+	})
+	store.Put(TriggerIdentity{
+		Identity: "b61a8bfa6c9b2eb1c",
+		Slug:     "other_trigger",
+		UserID:   "1",
+	})
+
+	if res, err := store.Get("92429d82a41e93048"); err != nil || res == nil || res.TriggerFields["foo"] != "bar" || res.Created.IsZero() {
+		t.Errorf("Unexpected identity: %v %v\n", res, err)
+		t.Fail()
+	}
+	if res, err := store.Get("unknown"); err != nil || res != nil {
+		t.Errorf("Unexpected identity: %v %v\n", res, err)
+		t.Fail()
+	}
+	if res, err := store.BySlug("test_trigger"); err != nil || len(res) != 1 || res[0].Identity != "92429d82a41e93048" {
+		t.Errorf("Unexpected identities: %v %v\n", res, err)
+		t.Fail()
+	}
+	if res, err := store.ByUser("1"); err != nil || len(res) != 2 {
+		t.Errorf("Unexpected identities: %v %v\n", res, err)
+		t.Fail()
+	}
+
+	if err := store.Remove("92429d82a41e93048"); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if err := store.Remove("92429d82a41e93048"); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if res, err := store.ByUser("1"); err != nil || len(res) != 1 || res[0].Identity != "b61a8bfa6c9b2eb1c" {
+		t.Errorf("Unexpected identities: %v %v\n", res, err)
+		t.Fail()
+	}
+}
+
+func TestMemoryIdentityStore(t *testing.T) {
+	testIdentityStore(t, NewMemoryIdentityStore())
+}
+
+func TestFileIdentityStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ifttt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "identities.json")
+
+	store, err := NewFileIdentityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIdentityStore(t, store)
+
+	reloaded, err := NewFileIdentityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := reloaded.BySlug("other_trigger"); err != nil || len(res) != 1 || res[0].UserID != "1" {
+		t.Errorf("Unexpected identities: %v %v\n", res, err)
+		t.Fail()
+	}
+	if res, err := reloaded.Get("92429d82a41e93048"); err != nil || res != nil {
+		t.Errorf("Unexpected identity: %v %v\n", res, err)
+		t.Fail()
+	}
+
+	// a failed save is retried by the next change, even if it does not change the identities
+	missing := filepath.Join(dir, "missing", "identities.json")
+	store, err = NewFileIdentityStore(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := TriggerIdentity{Identity: "abcd", Slug: "test_trigger", UserID: "1"}
+	if err := store.Put(identity); err == nil {
+		t.Fatalf("Expected save to fail\n")
+	}
+	if err := store.Put(identity); err == nil {
+		t.Fatalf("Expected retry to fail until a save succeeds\n")
+	}
+	if err := os.Mkdir(filepath.Dir(missing), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(identity); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if reloaded, err := NewFileIdentityStore(missing); err != nil || len(reloaded.identities) != 1 {
+		t.Errorf("Expected the retried identity to be saved: %v %v\n", reloaded, err)
+		t.Fail()
+	}
+}
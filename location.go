@@ -0,0 +1,122 @@
+package ifttt
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+// Location represents the value of a location field
+// https://platform.ifttt.com/docs/api_reference#location-fields
+type Location struct {
+	// Lat the latitude in degrees
+	Lat float64
+	// Lng the longitude in degrees
+	Lng float64
+	// Address the street address of the location, empty string if not available
+	Address string
+	// Description a description of the location, empty string if not available
+	Description string
+	// Radius the radius of the area in meters, 0 if the field is a point
+	Radius float64
+}
+
+// parseLocation converts the raw value of a location field into a Location
+func parseLocation(val interface{}) (Location, error) {
+	if loc, ok := val.(Location); ok {
+		return loc, nil
+	}
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return Location{}, fmt.Errorf("%s is not a location", fieldString(val))
+	}
+	number := func(key string, required bool) (float64, error) {
+		switch v := obj[key].(type) {
+		case float64:
+			return v, nil
+		case string:
+			if v == "" && !required {
+				return 0, nil
+			}
+			if res, err := strconv.ParseFloat(v, 64); err == nil {
+				return res, nil
+			}
+		case nil:
+			if !required {
+				return 0, nil
+			}
+		}
+		return 0, fmt.Errorf("location %s %s is not a number", key, fieldString(obj[key]))
+	}
+
+	res := Location{}
+	var err error
+	if res.Lat, err = number("lat", true); err != nil {
+		return Location{}, err
+	}
+	if res.Lng, err = number("lng", true); err != nil {
+		return Location{}, err
+	}
+	if res.Radius, err = number("radius", false); err != nil {
+		return Location{}, err
+	}
+	if res.Lat < -90 || res.Lat > 90 || res.Lng < -180 || res.Lng > 180 {
+		return Location{}, fmt.Errorf("location %v,%v is out of range", res.Lat, res.Lng)
+	}
+	res.Address, _ = obj["address"].(string)
+	res.Description, _ = obj["description"].(string)
+	return res, nil
+}
+
+// Distance returns the great-circle distance in meters between the center of this location and the point lat, lng
+func (c Location) Distance(lat, lng float64) float64 {
+	rad := func(deg float64) float64 {
+		return deg * math.Pi / 180
+	}
+	dLat := rad(lat - c.Lat)
+	dLng := rad(lng - c.Lng)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(c.Lat))*math.Cos(rad(lat))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// DistanceTo returns the great-circle distance in meters between the centers of two locations
+func (c Location) DistanceTo(other Location) float64 {
+	return c.Distance(other.Lat, other.Lng)
+}
+
+// Contains returns whether the point lat, lng lies within Radius meters of the center of this location
+func (c Location) Contains(lat, lng float64) bool {
+	return c.Distance(lat, lng) <= c.Radius
+}
+
+// Overlaps returns whether the areas of two locations intersect
+func (c Location) Overlaps(other Location) bool {
+	return c.DistanceTo(other) <= c.Radius+other.Radius
+}
+
+// Location returns the value of a location field, or an error if the field is absent or not a location
+func (c FieldValues) Location(slug string) (Location, error) {
+	val, ok := c[slug]
+	if !ok {
+		return Location{}, fmt.Errorf("location field %s not present", slug)
+	}
+	return parseLocation(val)
+}
+
+// Location returns the value of the location field identified by slug
+func (c *ActionHandleRequest) Location(slug string) (Location, error) {
+	return c.Fields.Location(slug)
+}
+
+// Location returns the value of the location field identified by slug
+func (c *TriggerPollRequest) Location(slug string) (Location, error) {
+	return c.Fields.Location(slug)
+}
+
+// Location returns the value of the location field identified by slug
+func (c *QueryRequest) Location(slug string) (Location, error) {
+	return c.Fields.Location(slug)
+}
@@ -0,0 +1,77 @@
+package ifttt
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLocation(t *testing.T) {
+	loc, err := parseLocation(map[string]interface{}{
+		"lat":         "37.7749",
+		"lng":         -122.4194,
+		"address":     "San Francisco, CA",
+		"description": "Home",
+		"radius":      "500",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if loc != (Location{37.7749, -122.4194, "San Francisco, CA", "Home", 500}) {
+		t.Errorf("Unexpected location: %+v\n", loc)
+		t.Fail()
+	}
+
+	for _, val := range []interface{}{
+		"home",
+		map[string]interface{}{"lat": "north", "lng": 0.0},
+		map[string]interface{}{"lat": 0.0},
+		map[string]interface{}{"lat": 91.0, "lng": 0.0},
+	} {
+		if _, err := parseLocation(val); err == nil {
+			t.Errorf("Invalid location accepted: %v\n", val)
+			t.Fail()
+		}
+	}
+}
+
+func TestLocationDistance(t *testing.T) {
+	sf := Location{Lat: 37.7749, Lng: -122.4194, Radius: 1000}
+	la := Location{Lat: 34.0522, Lng: -118.2437, Radius: 1000}
+
+	if d := sf.DistanceTo(la); math.Abs(d-559120) > 1000 {
+		t.Errorf("Unexpected distance: %v\n", d)
+		t.Fail()
+	}
+	if !sf.Contains(37.7800, -122.4194) || sf.Contains(la.Lat, la.Lng) {
+		t.Errorf("Unexpected containment")
+		t.Fail()
+	}
+	if sf.Overlaps(la) || !sf.Overlaps(Location{Lat: 37.7900, Lng: -122.4194, Radius: 1000}) {
+		t.Errorf("Unexpected overlap")
+		t.Fail()
+	}
+}
+
+func TestRequestLocation(t *testing.T) {
+	req := &TriggerPollRequest{
+		Fields: FieldValues{
+			"place": map[string]interface{}{"lat": 1.0, "lng": 2.0},
+		},
+	}
+	if loc, err := req.Location("place"); err != nil || loc.Lat != 1 || loc.Lng != 2 {
+		t.Errorf("Unexpected location: %v %v\n", loc, err)
+		t.Fail()
+	}
+	if _, err := req.Location("missing"); err == nil {
+		t.Errorf("Missing location accepted")
+		t.Fail()
+	}
+
+	fields := struct {
+		Place Location `ifttt:"place,required"`
+	}{}
+	if err := req.Decode(&fields); err != nil || fields.Place.Lng != 2 {
+		t.Errorf("Unexpected location: %v %v\n", fields.Place, err)
+		t.Fail()
+	}
+}
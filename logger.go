@@ -0,0 +1,203 @@
+package ifttt
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// LogLevel enumerates the severity of log messages
+type LogLevel int
+
+const (
+	// LevelDebug messages useful when debugging the service, eg: every request received
+	LevelDebug LogLevel = iota
+	// LevelInfo messages describing normal operation, eg: every request handled
+	LevelInfo
+	// LevelWarn messages describing refused requests and recoverable failures
+	LevelWarn
+	// LevelError messages describing failed requests
+	LevelError
+)
+
+// String implements fmt.Stringer
+func (c LogLevel) String() string {
+	switch c {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Logger is a leveled structured logger, args are alternating keys and values
+// *slog.Logger satisfies this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// RedactedValue replaces the logged values of redacted fields
+const RedactedValue = "[REDACTED]"
+
+// defaultRedactedFields are the log fields always redacted
+var defaultRedactedFields = []string{"service_key", "access_token", "refresh_token", "token", "authorization", "client_secret"}
+
+// StdLogger is a Logger writing key=value lines through a log.Logger
+type StdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger creates a StdLogger writing messages of level or above to w
+func NewStdLogger(w io.Writer, level LogLevel) *StdLogger {
+	return &StdLogger{
+		logger: log.New(w, "IFTTT: ", log.LstdFlags),
+		level:  level,
+	}
+}
+
+// formatLogValue formats a log value, quoting it if it contains spaces or quotes
+func formatLogValue(val interface{}) string {
+	str := fmt.Sprint(val)
+	if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+func (c *StdLogger) log(level LogLevel, msg string, args []interface{}) {
+	if level < c.level {
+		return
+	}
+	line := "level=" + level.String() + " msg=" + formatLogValue(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			line += " " + fmt.Sprint(args[i]) + "=" + formatLogValue(args[i+1])
+		} else {
+			line += " !BADKEY=" + formatLogValue(args[i])
+		}
+	}
+	c.logger.Println(line)
+}
+
+// Debug implements Logger
+func (c *StdLogger) Debug(msg string, args ...interface{}) {
+	c.log(LevelDebug, msg, args)
+}
+
+// Info implements Logger
+func (c *StdLogger) Info(msg string, args ...interface{}) {
+	c.log(LevelInfo, msg, args)
+}
+
+// Warn implements Logger
+func (c *StdLogger) Warn(msg string, args ...interface{}) {
+	c.log(LevelWarn, msg, args)
+}
+
+// Error implements Logger
+func (c *StdLogger) Error(msg string, args ...interface{}) {
+	c.log(LevelError, msg, args)
+}
+
+// fieldLogger adds fields to every message and redacts the values of sensitive fields before passing them to logger
+// A fieldLogger with a nil logger discards all messages.
+type fieldLogger struct {
+	logger Logger
+	fields []interface{}
+	redact map[string]bool
+}
+
+// newFieldLogger creates a fieldLogger redacting the default fields and extra fields
+func newFieldLogger(logger Logger, extra []string) *fieldLogger {
+	res := &fieldLogger{
+		logger: logger,
+		redact: make(map[string]bool),
+	}
+	for _, field := range defaultRedactedFields {
+		res.redact[field] = true
+	}
+	for _, field := range extra {
+		res.redact[strings.ToLower(field)] = true
+	}
+	return res
+}
+
+// with returns a fieldLogger adding the fields in args
+func (c *fieldLogger) with(args ...interface{}) *fieldLogger {
+	fields := make([]interface{}, 0, len(c.fields)+len(args))
+	fields = append(fields, c.fields...)
+	fields = append(fields, args...)
+	return &fieldLogger{c.logger, fields, c.redact}
+}
+
+func (c *fieldLogger) args(args []interface{}) []interface{} {
+	res := make([]interface{}, 0, len(c.fields)+len(args))
+	res = append(res, c.fields...)
+	res = append(res, args...)
+	for i := 0; i+1 < len(res); i += 2 {
+		if key, ok := res[i].(string); ok && c.redact[strings.ToLower(key)] {
+			res[i+1] = RedactedValue
+		}
+	}
+	return res
+}
+
+// Debug implements Logger
+func (c *fieldLogger) Debug(msg string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Debug(msg, c.args(args)...)
+	}
+}
+
+// Info implements Logger
+func (c *fieldLogger) Info(msg string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Info(msg, c.args(args)...)
+	}
+}
+
+// Warn implements Logger
+func (c *fieldLogger) Warn(msg string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Warn(msg, c.args(args)...)
+	}
+}
+
+// Error implements Logger
+func (c *fieldLogger) Error(msg string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Error(msg, c.args(args)...)
+	}
+}
+
+// statusWriter records the status code written to a http.ResponseWriter
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (c *statusWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *statusWriter) Write(data []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.ResponseWriter.Write(data)
+}
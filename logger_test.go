@@ -0,0 +1,103 @@
+package ifttt
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	lock    sync.Mutex
+	entries []logEntry
+}
+
+func (c *recordingLogger) log(level LogLevel, msg string, args []interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[fmt.Sprint(args[i])] = args[i+1]
+	}
+	c.lock.Lock()
+	c.entries = append(c.entries, logEntry{level, msg, fields})
+	c.lock.Unlock()
+}
+
+func (c *recordingLogger) Debug(msg string, args ...interface{}) { c.log(LevelDebug, msg, args) }
+func (c *recordingLogger) Info(msg string, args ...interface{})  { c.log(LevelInfo, msg, args) }
+func (c *recordingLogger) Warn(msg string, args ...interface{})  { c.log(LevelWarn, msg, args) }
+func (c *recordingLogger) Error(msg string, args ...interface{}) { c.log(LevelError, msg, args) }
+
+func (c *recordingLogger) find(msg string) *logEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i := range c.entries {
+		if c.entries[i].msg == msg {
+			return &c.entries[i]
+		}
+	}
+	return nil
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewStdLogger(buf, LevelInfo)
+	logger.Debug("hidden")
+	logger.Info("Request handled", "status", 200, "path", "/ifttt/v1/status", "error", "Token invalid")
+	logger.Warn("odd", "dangling")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("Debug message should be filtered: %s\n", out)
+		t.Fail()
+	}
+	if !strings.Contains(out, `level=INFO msg="Request handled" status=200 path=/ifttt/v1/status error="Token invalid"`) {
+		t.Errorf("Unexpected output: %s\n", out)
+		t.Fail()
+	}
+	if !strings.Contains(out, "level=WARN msg=odd !BADKEY=dangling") {
+		t.Errorf("Unexpected output: %s\n", out)
+		t.Fail()
+	}
+}
+
+func TestServiceLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	service := &Service{ServiceKey: "servicekey", Logger: logger, RedactFields: []string{"Email"}}
+
+	req := httptest.NewRequest("GET", "/ifttt/v1/status", bytes.NewBufferString(""))
+	req.Header.Set("IFTTT-Service-Key", "wrongkey")
+	req.Header.Set("X-Request-ID", "abc")
+	service.ServeHTTP(httptest.NewRecorder(), req)
+
+	refused := logger.find("Request refused due to incorrect service key")
+	if refused == nil || refused.level != LevelWarn || refused.fields["service_key"] != RedactedValue || refused.fields["request_id"] != "abc" {
+		t.Fatalf("Unexpected refusal log: %v\n", refused)
+	}
+	handled := logger.find("Request handled")
+	if handled == nil || handled.fields["status"] != 401 || handled.fields["type"] != "service_status" || handled.fields["error"] != "Service Key does not present." {
+		t.Fatalf("Unexpected request log: %v\n", handled)
+	}
+	if _, ok := handled.fields["latency"]; !ok {
+		t.Errorf("Expected latency field: %v\n", handled)
+		t.Fail()
+	}
+
+	redacting := newFieldLogger(logger, service.RedactFields).with("email", "john@example.com")
+	redacting.Info("custom", "Access_Token", "secret", "name", "John")
+	custom := logger.find("custom")
+	if custom.fields["email"] != RedactedValue || custom.fields["Access_Token"] != RedactedValue || custom.fields["name"] != "John" {
+		t.Errorf("Unexpected redaction: %v\n", custom)
+		t.Fail()
+	}
+
+	// a nil logger discards messages
+	newFieldLogger(nil, nil).Error("discarded")
+}
@@ -0,0 +1,212 @@
+package ifttt
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultLatencyBuckets are the histogram buckets of latencies in seconds
+	DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// DefaultEventBuckets are the histogram buckets of the number of events returned per poll
+	DefaultEventBuckets = []float64{0, 1, 5, 10, 25, 50, 100}
+)
+
+// metricSeries is a single labeled counter or histogram
+type metricSeries struct {
+	labels  []string
+	value   float64
+	sum     float64
+	buckets []uint64
+}
+
+// metricFamily is a metric with its labeled series
+type metricFamily struct {
+	name       string
+	help       string
+	histogram  bool
+	labelNames []string
+	buckets    []float64
+	series     map[string]*metricSeries
+}
+
+func (c *metricFamily) get(labels []string) *metricSeries {
+	key := strings.Join(labels, "\xff")
+	res, ok := c.series[key]
+	if !ok {
+		res = &metricSeries{labels: labels}
+		if c.histogram {
+			res.buckets = make([]uint64, len(c.buckets))
+		}
+		c.series[key] = res
+	}
+	return res
+}
+
+// escapeLabel escapes a label value of the Prometheus text format
+func escapeLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
+
+func formatMetricValue(val float64) string {
+	if math.IsInf(val, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+func (c *metricFamily) labelString(labels []string, extra ...string) string {
+	pairs := make([]string, 0, len(labels)+1)
+	for i, name := range c.labelNames {
+		pairs = append(pairs, name+`="`+escapeLabel(labels[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (c *metricFamily) write(b *strings.Builder) {
+	typ := "counter"
+	if c.histogram {
+		typ = "histogram"
+	}
+	b.WriteString("# HELP " + c.name + " " + c.help + "\n")
+	b.WriteString("# TYPE " + c.name + " " + typ + "\n")
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := c.series[key]
+		if !c.histogram {
+			b.WriteString(c.name + c.labelString(series.labels) + " " + formatMetricValue(series.value) + "\n")
+			continue
+		}
+		for i, bound := range c.buckets {
+			b.WriteString(c.name + "_bucket" + c.labelString(series.labels, "le", formatMetricValue(bound)) + " " + strconv.FormatUint(series.buckets[i], 10) + "\n")
+		}
+		b.WriteString(c.name + "_bucket" + c.labelString(series.labels, "le", "+Inf") + " " + formatMetricValue(series.value) + "\n")
+		b.WriteString(c.name + "_sum" + c.labelString(series.labels) + " " + formatMetricValue(series.sum) + "\n")
+		b.WriteString(c.name + "_count" + c.labelString(series.labels) + " " + formatMetricValue(series.value) + "\n")
+	}
+}
+
+// Metrics collects counters and histograms of the requests handled and notifications sent by a Service
+// It is served in the Prometheus text format by ServeHTTP, each Metrics keeps its own metrics so no global registry is involved.
+type Metrics struct {
+	lock     sync.Mutex
+	families []*metricFamily
+
+	requests      *metricFamily
+	latency       *metricFamily
+	panics        *metricFamily
+	pollEvents    *metricFamily
+	notifications *metricFamily
+	notifyEntries *metricFamily
+	notifyLatency *metricFamily
+}
+
+// NewMetrics creates an empty Metrics, set it as Service.Metrics to instrument the service
+func NewMetrics() *Metrics {
+	res := &Metrics{}
+	res.requests = res.family("ifttt_requests_total", "Requests handled by type, slug and status code.", nil, "type", "slug", "status")
+	res.latency = res.family("ifttt_request_duration_seconds", "Latency of handled requests in seconds by type and slug.", DefaultLatencyBuckets, "type", "slug")
+	res.panics = res.family("ifttt_panics_total", "Panics recovered while handling requests by type and slug.", nil, "type", "slug")
+	res.pollEvents = res.family("ifttt_poll_events", "Events returned per trigger poll by slug.", DefaultEventBuckets, "slug")
+	res.notifications = res.family("ifttt_notifications_total", "Realtime notification requests by result (HTTP status code or error).", nil, "result")
+	res.notifyEntries = res.family("ifttt_notification_entries_total", "User ids and trigger identities sent in realtime notifications.", nil)
+	res.notifyLatency = res.family("ifttt_notification_duration_seconds", "Latency of realtime notification requests in seconds.", DefaultLatencyBuckets)
+	return res
+}
+
+func (c *Metrics) family(name string, help string, buckets []float64, labelNames ...string) *metricFamily {
+	res := &metricFamily{
+		name:       name,
+		help:       help,
+		histogram:  buckets != nil,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*metricSeries),
+	}
+	c.families = append(c.families, res)
+	return res
+}
+
+func (c *Metrics) inc(family *metricFamily, labels ...string) {
+	c.lock.Lock()
+	family.get(labels).value++
+	c.lock.Unlock()
+}
+
+func (c *Metrics) add(family *metricFamily, val float64, labels ...string) {
+	c.lock.Lock()
+	family.get(labels).value += val
+	c.lock.Unlock()
+}
+
+func (c *Metrics) observe(family *metricFamily, val float64, labels ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	series := family.get(labels)
+	series.value++
+	series.sum += val
+	for i, bound := range family.buckets {
+		if val <= bound {
+			series.buckets[i]++
+		}
+	}
+}
+
+// observeRequest records a handled request
+func (c *Metrics) observeRequest(typ RequestType, slug string, status int, latency time.Duration, panicked bool) {
+	c.inc(c.requests, typ.String(), slug, strconv.Itoa(status))
+	c.observe(c.latency, latency.Seconds(), typ.String(), slug)
+	if panicked {
+		c.inc(c.panics, typ.String(), slug)
+	}
+}
+
+// observePoll records the number of events returned by a trigger poll
+func (c *Metrics) observePoll(slug string, events int) {
+	c.observe(c.pollEvents, float64(events), slug)
+}
+
+// observeNotification records a realtime notification request
+func (c *Metrics) observeNotification(entries int, err error, latency time.Duration) {
+	result := "200"
+	if err != nil {
+		var realtimeErr RealtimeError
+		if errors.As(err, &realtimeErr) {
+			result = strconv.Itoa(realtimeErr.StatusCode)
+		} else {
+			result = "error"
+		}
+	}
+	c.inc(c.notifications, result)
+	c.add(c.notifyEntries, float64(entries))
+	c.observe(c.notifyLatency, latency.Seconds())
+}
+
+// ServeHTTP implements http.Handler, serving the metrics in the Prometheus text format
+func (c *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b := new(strings.Builder)
+	c.lock.Lock()
+	for _, family := range c.families {
+		family.write(b)
+	}
+	c.lock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(200)
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,86 @@
+package ifttt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	realtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer realtime.Close()
+
+	metrics := NewMetrics()
+	service := &Service{ServiceKey: "servicekey", Metrics: metrics, RealtimeURL: realtime.URL}
+	service.RegisterTrigger("test_trigger", testTrigger{})
+	service.RegisterAction("panic_action", testAction{true})
+
+	do := func(method string, path string, body string) {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("IFTTT-Service-Key", "servicekey")
+		req.Header.Set("X-Request-ID", "abc")
+		service.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("POST", "/ifttt/v1/triggers/test_trigger", `{"trigger_identity":"a","triggerFields":{"foo":"bar"},"user":{}}`)
+	do("POST", "/ifttt/v1/triggers/test_trigger", `{"trigger_identity":"a","triggerFields":{},"limit":0,"user":{}}`)
+	do("POST", "/ifttt/v1/triggers/no_such_trigger", `{"trigger_identity":"a","triggerFields":{}}`)
+	// slugs of requests refused before routing are not used as labels
+	unauthorized := httptest.NewRequest("POST", "/ifttt/v1/triggers/random_slug", bytes.NewBufferString(`{}`))
+	service.ServeHTTP(httptest.NewRecorder(), unauthorized)
+	do("POST", "/ifttt/v1/actions/panic_action", `{"actionFields":{},"user":{"timezone":"UTC"}}`)
+
+	evt := Notification{}
+	evt.AddUser("alice")
+	evt.AddTrigger("a")
+	if err := service.Notify(evt); err == nil {
+		t.Errorf("Expected notify error\n")
+		t.Fail()
+	}
+
+	res := httptest.NewRecorder()
+	metrics.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	out, _ := ioutil.ReadAll(res.Body)
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type: %s\n", res.Header().Get("Content-Type"))
+		t.Fail()
+	}
+	for _, line := range []string{
+		"# TYPE ifttt_requests_total counter",
+		`ifttt_requests_total{type="trigger_fetch",slug="test_trigger",status="200"} 2`,
+		`ifttt_requests_total{type="trigger_fetch",slug="",status="404"} 1`,
+		`ifttt_requests_total{type="trigger_fetch",slug="",status="401"} 1`,
+		`ifttt_requests_total{type="action_trigger",slug="panic_action",status="500"} 1`,
+		`ifttt_panics_total{type="action_trigger",slug="panic_action"} 1`,
+		"# TYPE ifttt_request_duration_seconds histogram",
+		`ifttt_request_duration_seconds_count{type="trigger_fetch",slug="test_trigger"} 2`,
+		`ifttt_poll_events_bucket{slug="test_trigger",le="0"} 1`,
+		`ifttt_poll_events_bucket{slug="test_trigger",le="1"} 2`,
+		`ifttt_poll_events_bucket{slug="test_trigger",le="+Inf"} 2`,
+		`ifttt_poll_events_sum{slug="test_trigger"} 1`,
+		`ifttt_notifications_total{result="503"} 1`,
+		"ifttt_notification_entries_total 2",
+		"ifttt_notification_duration_seconds_count 1",
+	} {
+		if !strings.Contains(string(out), line+"\n") {
+			t.Errorf("Missing metric line %q in:\n%s\n", line, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeRequest(TriggerFetch, "a\"b\\c\nd", 200, time.Millisecond, false)
+	res := httptest.NewRecorder()
+	metrics.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(res.Body.String(), `slug="a\"b\\c\nd"`) {
+		t.Errorf("Unexpected escaping:\n%s\n", res.Body.String())
+		t.Fail()
+	}
+}
@@ -0,0 +1,78 @@
+package ifttt
+
+import (
+	"context"
+	"net/http"
+)
+
+// Response is the response to a request from IFTTT, it is only written after every middleware returned
+// so middlewares can inspect or replace the response of the handler.
+type Response struct {
+	// Status the HTTP status code, defaults to 200, ignored if Err is set
+	Status int
+	// Header the headers added to the response
+	Header http.Header
+	// Body the marshaled JSON body, ignored if Err is set
+	Body []byte
+	// Err the error the request failed with, it is answered with the status code of its type (see errorStatus) like errors returned by handlers
+	Err error
+	// Skip whether the error is reported with the SKIP status, only used by actions
+	Skip bool
+}
+
+// HandlerFunc handles a parsed and authenticated request and returns its response
+type HandlerFunc func(ctx context.Context, req *Request) *Response
+
+// Middleware wraps the HandlerFunc dispatching requests to the registered handlers
+// It may call next, handle the request itself (eg: answering from a cache) or modify the response returned by next.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middlewares to the service
+// Middlewares run in the order they are added, the first one added is the outermost and sees the request first and the response last.
+// They are called after the request is parsed and authenticated, and only for slugs with a registered handler.
+func (c *Service) Use(middlewares ...Middleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// chain returns the dispatch HandlerFunc wrapped by the middlewares of the service
+func (c *Service) chain() HandlerFunc {
+	handler := c.dispatch
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler)
+	}
+	return handler
+}
+
+// ForSlugs returns a Middleware which applies mw only to requests to the given slugs
+func ForSlugs(mw Middleware, slugs ...string) Middleware {
+	set := make(map[string]bool)
+	for _, slug := range slugs {
+		set[slug] = true
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		wrapped := mw(next)
+		return func(ctx context.Context, req *Request) *Response {
+			if set[req.Slug] {
+				return wrapped(ctx, req)
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// ForTypes returns a Middleware which applies mw only to requests of the given types
+func ForTypes(mw Middleware, types ...RequestType) Middleware {
+	set := make(map[RequestType]bool)
+	for _, typ := range types {
+		set[typ] = true
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		wrapped := mw(next)
+		return func(ctx context.Context, req *Request) *Response {
+			if set[req.Type] {
+				return wrapped(ctx, req)
+			}
+			return next(ctx, req)
+		}
+	}
+}
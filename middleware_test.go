@@ -0,0 +1,84 @@
+package ifttt
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	service := &Service{ServiceKey: "servicekey"}
+	service.RegisterTrigger("test_trigger", testTrigger{})
+	service.RegisterAction("test_action", testAction{})
+
+	order := make([]string, 0)
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, req *Request) *Response {
+				order = append(order, name+" "+req.Slug)
+				res := next(ctx, req)
+				order = append(order, name+" done")
+				return res
+			}
+		}
+	}
+	service.Use(trace("outer"), trace("inner"))
+	service.Use(ForSlugs(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) *Response {
+			res := next(ctx, req)
+			if res.Header == nil {
+				t.Fatalf("Expected the header of the poll response\n")
+			}
+			res.Header.Set("X-Audited", "1")
+			return res
+		}
+	}, "test_trigger"))
+	service.Use(ForTypes(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) *Response {
+			return &Response{Err: RateLimitedError{}, Skip: true}
+		}
+	}, ActionTrigger))
+
+	do := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("IFTTT-Service-Key", "servicekey")
+		res := httptest.NewRecorder()
+		service.ServeHTTP(res, req)
+		return res
+	}
+
+	res := do("/ifttt/v1/triggers/test_trigger", `{"trigger_identity":"a","triggerFields":{"foo":"bar"},"user":{}}`)
+	if res.Code != 200 || res.Header().Get("X-Audited") != "1" || res.Header().Get("X-IFTTT-Realtime") != "1" {
+		t.Errorf("Unexpected poll response: %d %v\n", res.Code, res.Header())
+		t.Fail()
+	}
+	if !stringSliceEqual(order, []string{"outer test_trigger", "inner test_trigger", "inner done", "outer done"}) {
+		t.Errorf("Unexpected middleware order: %v\n", order)
+		t.Fail()
+	}
+
+	res = do("/ifttt/v1/actions/test_action", `{"actionFields":{},"user":{}}`)
+	if res.Code != 429 || res.Header().Get("X-Audited") != "" || !jsonEqual(res.Body.Bytes(), []byte(`{"errors":[{"message":"Rate limit exceeded"}]}`)) {
+		t.Errorf("Unexpected action response: %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+
+	// requests to unregistered slugs never reach middlewares
+	order = order[:0]
+	res = do("/ifttt/v1/triggers/unknown_trigger", `{"trigger_identity":"a","triggerFields":{}}`)
+	if res.Code != 404 || len(order) != 0 {
+		t.Errorf("Unexpected unknown trigger response: %d %v\n", res.Code, order)
+		t.Fail()
+	}
+
+	service.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) *Response {
+			return nil
+		}
+	})
+	if res = do("/ifttt/v1/triggers/test_trigger", `{"trigger_identity":"a","triggerFields":{"foo":"bar"}}`); res.Code != 500 {
+		t.Errorf("Expected 500 for nil response, got %d\n", res.Code)
+		t.Fail()
+	}
+}
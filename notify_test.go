@@ -1,6 +1,12 @@
 package ifttt
 
-import "testing"
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
 
 func TestNotifyMarshal(t *testing.T) {
 	not := Notification{}
@@ -12,3 +18,50 @@ func TestNotifyMarshal(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestServiceNotify(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path != "/v1/notifications" || r.Header.Get("IFTTT-Service-Key") != "vFRqPGZBmZjB8JPp3mBFqOdt" || !jsonEqual(body, []byte(`{"data":[{"user_id":"foo"}]}`)) {
+			w.WriteHeader(400)
+			return
+		}
+		if fail {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(503)
+			w.Write([]byte(`{"errors":[{"message":"Try again later"}]}`))
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	service := &Service{
+		ServiceKey:  "vFRqPGZBmZjB8JPp3mBFqOdt",
+		RealtimeURL: server.URL + "/",
+		HTTPClient:  server.Client(),
+	}
+	not := Notification{}
+	not.AddUser("foo")
+
+	if err := service.Notify(not); err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+
+	fail = true
+	err := service.Notify(not)
+	realtimeErr, ok := err.(RealtimeError)
+	if !ok {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if realtimeErr.StatusCode != 503 || realtimeErr.RetryAfter != 30*time.Second || len(realtimeErr.Messages) != 1 || realtimeErr.Messages[0] != "Try again later" || !realtimeErr.Temporary() {
+		t.Errorf("Unexpected error: %+v\n", realtimeErr)
+		t.Fail()
+	}
+	if realtimeErr.Error() != "Remote returned code 503 with: Try again later" {
+		t.Errorf("Unexpected error message: %s\n", realtimeErr.Error())
+		t.Fail()
+	}
+}
@@ -0,0 +1,585 @@
+package ifttt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+var (
+	// ErrorOAuth2AccessDenied can be returned by OAuth2Provider.Consent when the user refused to authorize the client
+	ErrorOAuth2AccessDenied = errors.New("Access denied")
+)
+
+// OAuth2Client describes a client allowed to request authorization, which is usually IFTTT itself
+// Get the client id, secret and redirect URI from the authentication settings of your service on the IFTTT dashboard.
+type OAuth2Client struct {
+	// ID the client id
+	ID string
+	// Secret the client secret
+	Secret string
+	// RedirectURIs the redirect URIs the client may request, eg: https://ifttt.com/channels/<service_slug>/authorize
+	RedirectURIs []string
+}
+
+// OAuth2Code is an authorization code issued by the authorize endpoint
+type OAuth2Code struct {
+	// Code the authorization code
+	Code string
+	// ClientID the id of the client the code was issued to
+	ClientID string
+	// UserID the id of the user who granted the authorization
+	UserID string
+	// RedirectURI the redirect URI the code was sent to
+	RedirectURI string
+	// RedirectURIProvided whether redirect_uri was present in the authorization request, the token request must then repeat it
+	RedirectURIProvided bool
+	// Expires the expiry time of the code
+	Expires time.Time
+}
+
+// OAuth2Token is an access token and its refresh token issued by the token endpoint
+type OAuth2Token struct {
+	// AccessToken the access token sent by IFTTT as the bearer token
+	AccessToken string
+	// RefreshToken the refresh token exchanged for a new token pair when the access token expires
+	RefreshToken string
+	// ClientID the id of the client the token was issued to
+	ClientID string
+	// UserID the id of the user who granted the authorization
+	UserID string
+	// Expires the expiry time of the access token, zero time if it never expires
+	Expires time.Time
+	// RefreshExpires the expiry time of the refresh token, zero time if it never expires
+	RefreshExpires time.Time
+}
+
+// Expired returns whether the access token has expired
+func (c OAuth2Token) Expired() bool {
+	return !c.Expires.IsZero() && time.Now().After(c.Expires)
+}
+
+// RefreshExpired returns whether the refresh token has expired
+func (c OAuth2Token) RefreshExpired() bool {
+	return !c.RefreshExpires.IsZero() && time.Now().After(c.RefreshExpires)
+}
+
+// OAuth2Store is the storage backend of clients, authorization codes and tokens of an OAuth2Provider
+type OAuth2Store interface {
+	// Client returns the client identified by id, or nil if it does not exist
+	Client(id string) (*OAuth2Client, error)
+	// PutCode records an authorization code
+	PutCode(code OAuth2Code) error
+	// TakeCode returns and removes the authorization code, or nil if it was not recorded
+	// A code must not be returned twice.
+	TakeCode(code string) (*OAuth2Code, error)
+	// PutToken records a token pair
+	PutToken(token OAuth2Token) error
+	// AccessToken returns the token pair of an access token, or nil if it was not recorded
+	AccessToken(token string) (*OAuth2Token, error)
+	// RefreshToken returns the token pair of a refresh token, or nil if it was not recorded
+	RefreshToken(token string) (*OAuth2Token, error)
+	// TakeRefreshToken returns and removes the token pair of a refresh token, or nil if it was not recorded
+	// It must be atomic so that concurrent refreshes cannot redeem the same refresh token twice.
+	TakeRefreshToken(token string) (*OAuth2Token, error)
+	// RemoveToken removes a token pair, removing a token pair which was not recorded is not an error
+	RemoveToken(token OAuth2Token) error
+}
+
+// OAuth2UserStore resolves the users who granted authorization
+type OAuth2UserStore interface {
+	// UserInfo returns the user info of the user identified by userid
+	UserInfo(userid string) (*UserInfo, error)
+}
+
+// OAuth2AuthorizeRequest describes a valid request to the authorize endpoint
+type OAuth2AuthorizeRequest struct {
+	// Client the client requesting authorization
+	Client OAuth2Client
+	// RedirectURI the redirect URI the user will be sent to
+	RedirectURI string
+	// State the opaque value which will be passed back to the client
+	State string
+	// Scope the scope requested, empty string if not requested
+	Scope string
+}
+
+// OAuth2Provider is an OAuth2 authorization server implementing the authorization code flow with refresh tokens and revocation
+// It serves /oauth2/authorize, /oauth2/token and /oauth2/revoke when set as Service.OAuth2, and resolves the access tokens of requests from IFTTT into Request.UserID.
+type OAuth2Provider struct {
+	// Store keeps the clients, authorization codes and tokens
+	Store OAuth2Store
+	// Users resolves the user info of authorized users, used to answer user info requests when Service.UserInfo is nil
+	Users OAuth2UserStore
+	// Consent is called on each valid request to the authorize endpoint to log the user in and ask for consent.
+	// It should return the id of the user once the authorization is granted, or ErrorOAuth2AccessDenied if the user refused.
+	// If the user has not logged in or consented yet, it should write a response (eg: a login page which submits back to the authorize endpoint) and return an empty user id.
+	Consent func(w http.ResponseWriter, r *http.Request, req *OAuth2AuthorizeRequest) (userid string, err error)
+	// CodeTTL the lifetime of authorization codes, defaults to 10 minutes
+	CodeTTL time.Duration
+	// TokenTTL the lifetime of access tokens, 0 for access tokens which never expire
+	TokenTTL time.Duration
+	// RefreshTokenTTL the lifetime of refresh tokens, 0 for refresh tokens which never expire
+	// Token pairs are kept by MemoryOAuth2Store until their refresh token expires.
+	RefreshTokenTTL time.Duration
+	// Revoked is called with each token pair revoked by the revoke endpoint or rotated by a refresh, eg: to drop it from caches
+	// NewCachingAuthenticator sets it when wrapping the provider.
+	Revoked func(token OAuth2Token)
+}
+
+// oauth2Error writes an error response of the token and revoke endpoints
+func oauth2Error(w http.ResponseWriter, code int, err string, description string) {
+	res := gabs.New()
+	res.Set(err, "error")
+	if len(description) > 0 {
+		res.Set(description, "error_description")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(res.Bytes())
+}
+
+// randomToken returns a random hex string used as codes and tokens
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ServeHTTP implements http.Handler, serving the authorize, token and revoke endpoints
+func (c *OAuth2Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth2/authorize":
+		c.authorize(w, r)
+	case "/oauth2/token":
+		c.token(w, r)
+	case "/oauth2/revoke":
+		c.revoke(w, r)
+	default:
+		oauth2Error(w, 404, "not_found", "")
+	}
+}
+
+// Resolve returns the token pair of a valid access token, or an AuthError if the token is unknown or expired
+func (c *OAuth2Provider) Resolve(accessToken string) (*OAuth2Token, error) {
+	token, err := c.Store.AccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.Expired() {
+		return nil, AuthError{}
+	}
+	return token, nil
+}
+
+func (c *OAuth2Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		oauth2Error(w, 405, "invalid_request", "Method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauth2Error(w, 400, "invalid_request", err.Error())
+		return
+	}
+	client, err := c.Store.Client(r.Form.Get("client_id"))
+	if err != nil {
+		oauth2Error(w, 500, "server_error", err.Error())
+		return
+	}
+	if client == nil {
+		oauth2Error(w, 400, "invalid_client", "Unknown client")
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	provided := redirectURI != ""
+	if !provided && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	allowed := false
+	for _, uri := range client.RedirectURIs {
+		if uri == redirectURI {
+			allowed = true
+			break
+		}
+	}
+	redirect, err := url.Parse(redirectURI)
+	if !allowed || err != nil {
+		// never redirect to an unregistered URI
+		oauth2Error(w, 400, "invalid_request", "Invalid redirect_uri")
+		return
+	}
+
+	req := &OAuth2AuthorizeRequest{
+		Client:      *client,
+		RedirectURI: redirectURI,
+		State:       r.Form.Get("state"),
+		Scope:       r.Form.Get("scope"),
+	}
+	redirectWith := func(params map[string]string) {
+		query := redirect.Query()
+		for key, val := range params {
+			query.Set(key, val)
+		}
+		if req.State != "" {
+			query.Set("state", req.State)
+		}
+		redirect.RawQuery = query.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		redirectWith(map[string]string{"error": "unsupported_response_type"})
+		return
+	}
+	if c.Consent == nil {
+		redirectWith(map[string]string{"error": "server_error"})
+		return
+	}
+	userid, err := c.Consent(w, r, req)
+	if err == ErrorOAuth2AccessDenied {
+		redirectWith(map[string]string{"error": "access_denied"})
+		return
+	} else if err != nil {
+		redirectWith(map[string]string{"error": "server_error"})
+		return
+	}
+	if userid == "" {
+		// the consent hook has written the login or consent page
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		redirectWith(map[string]string{"error": "server_error"})
+		return
+	}
+	ttl := c.CodeTTL
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	if err := c.Store.PutCode(OAuth2Code{
+		Code:                code,
+		ClientID:            client.ID,
+		UserID:              userid,
+		RedirectURI:         redirectURI,
+		RedirectURIProvided: provided,
+		Expires:             time.Now().Add(ttl),
+	}); err != nil {
+		redirectWith(map[string]string{"error": "server_error"})
+		return
+	}
+	redirectWith(map[string]string{"code": code})
+}
+
+// authenticateClient returns the client authenticated by HTTP basic auth or the client_id and client_secret parameters, or nil if the credentials are invalid
+func (c *OAuth2Provider) authenticateClient(r *http.Request) (*OAuth2Client, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := c.Store.Client(id)
+	if err != nil || client == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) != 1 {
+		return nil, nil
+	}
+	return client, nil
+}
+
+// issue creates, records and writes a new token pair
+func (c *OAuth2Provider) issue(w http.ResponseWriter, clientID string, userid string) {
+	access, err := randomToken()
+	if err != nil {
+		oauth2Error(w, 500, "server_error", err.Error())
+		return
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		oauth2Error(w, 500, "server_error", err.Error())
+		return
+	}
+	token := OAuth2Token{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ClientID:     clientID,
+		UserID:       userid,
+	}
+	if c.TokenTTL > 0 {
+		token.Expires = time.Now().Add(c.TokenTTL)
+	}
+	if c.RefreshTokenTTL > 0 {
+		token.RefreshExpires = time.Now().Add(c.RefreshTokenTTL)
+	}
+	if err := c.Store.PutToken(token); err != nil {
+		oauth2Error(w, 500, "server_error", err.Error())
+		return
+	}
+
+	res := gabs.New()
+	res.Set("Bearer", "token_type")
+	res.Set(token.AccessToken, "access_token")
+	res.Set(token.RefreshToken, "refresh_token")
+	if c.TokenTTL > 0 {
+		res.Set(int(c.TokenTTL/time.Second), "expires_in")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(res.Bytes())
+}
+
+func (c *OAuth2Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		oauth2Error(w, 405, "invalid_request", "Method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauth2Error(w, 400, "invalid_request", err.Error())
+		return
+	}
+	client, err := c.authenticateClient(r)
+	if err != nil {
+		oauth2Error(w, 500, "server_error", err.Error())
+		return
+	}
+	if client == nil {
+		oauth2Error(w, 401, "invalid_client", "")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := c.Store.TakeCode(r.PostForm.Get("code"))
+		if err != nil {
+			oauth2Error(w, 500, "server_error", err.Error())
+			return
+		}
+		// the redirect URI must be the one the code was sent to if it was in the authorization request (RFC 6749 4.1.3)
+		if code == nil || code.ClientID != client.ID || time.Now().After(code.Expires) || (code.RedirectURIProvided && r.PostForm.Get("redirect_uri") != code.RedirectURI) {
+			oauth2Error(w, 400, "invalid_grant", "")
+			return
+		}
+		c.issue(w, client.ID, code.UserID)
+	case "refresh_token":
+		// the grant is checked before the pair is taken, so other clients can not destroy it
+		token, err := c.Store.RefreshToken(r.PostForm.Get("refresh_token"))
+		if err != nil {
+			oauth2Error(w, 500, "server_error", err.Error())
+			return
+		}
+		if token == nil || token.ClientID != client.ID || token.RefreshExpired() {
+			oauth2Error(w, 400, "invalid_grant", "")
+			return
+		}
+		// refresh tokens are rotated, the old pair is taken atomically so it can only be redeemed once
+		token, err = c.Store.TakeRefreshToken(token.RefreshToken)
+		if err != nil {
+			oauth2Error(w, 500, "server_error", err.Error())
+			return
+		}
+		if token == nil {
+			oauth2Error(w, 400, "invalid_grant", "")
+			return
+		}
+		if c.Revoked != nil {
+			c.Revoked(*token)
+		}
+		c.issue(w, client.ID, token.UserID)
+	default:
+		oauth2Error(w, 400, "unsupported_grant_type", "")
+	}
+}
+
+func (c *OAuth2Provider) revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		oauth2Error(w, 405, "invalid_request", "Method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauth2Error(w, 400, "invalid_request", err.Error())
+		return
+	}
+	client, err := c.authenticateClient(r)
+	if err != nil {
+		oauth2Error(w, 500, "server_error", err.Error())
+		return
+	}
+	if client == nil {
+		oauth2Error(w, 401, "invalid_client", "")
+		return
+	}
+
+	value := r.PostForm.Get("token")
+	lookups := []func(string) (*OAuth2Token, error){c.Store.AccessToken, c.Store.RefreshToken}
+	if r.PostForm.Get("token_type_hint") == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		token, err := lookup(value)
+		if err != nil {
+			oauth2Error(w, 500, "server_error", err.Error())
+			return
+		}
+		if token != nil {
+			if token.ClientID == client.ID {
+				if err := c.Store.RemoveToken(*token); err != nil {
+					oauth2Error(w, 500, "server_error", err.Error())
+					return
+				}
+				if c.Revoked != nil {
+					c.Revoked(*token)
+				}
+			}
+			break
+		}
+	}
+	// unknown tokens are not an error according to RFC 7009
+	w.WriteHeader(200)
+}
+
+// MemoryOAuth2Store is an OAuth2Store which keeps clients, authorization codes and tokens in memory
+// Expired codes, and token pairs whose refresh token expired, are dropped periodically.
+type MemoryOAuth2Store struct {
+	lock      sync.Mutex
+	clients   map[string]OAuth2Client
+	codes     map[string]OAuth2Code
+	access    map[string]OAuth2Token
+	refresh   map[string]string
+	lastSweep time.Time
+}
+
+// NewMemoryOAuth2Store creates a MemoryOAuth2Store with the clients
+func NewMemoryOAuth2Store(clients ...OAuth2Client) *MemoryOAuth2Store {
+	res := &MemoryOAuth2Store{
+		clients: make(map[string]OAuth2Client),
+		codes:   make(map[string]OAuth2Code),
+		access:  make(map[string]OAuth2Token),
+		refresh: make(map[string]string),
+
+		lastSweep: time.Now(),
+	}
+	for _, client := range clients {
+		res.clients[client.ID] = client
+	}
+	return res
+}
+
+// sweep drops expired codes and token pairs at most once per minute, c.lock must be held
+func (c *MemoryOAuth2Store) sweep() {
+	now := time.Now()
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	for key, code := range c.codes {
+		if now.After(code.Expires) {
+			delete(c.codes, key)
+		}
+	}
+	for key, token := range c.access {
+		if token.RefreshExpired() {
+			delete(c.access, key)
+			delete(c.refresh, token.RefreshToken)
+		}
+	}
+	c.lastSweep = now
+}
+
+// Client implements OAuth2Store
+func (c *MemoryOAuth2Store) Client(id string) (*OAuth2Client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if client, ok := c.clients[id]; ok {
+		return &client, nil
+	}
+	return nil, nil
+}
+
+// PutCode implements OAuth2Store
+func (c *MemoryOAuth2Store) PutCode(code OAuth2Code) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sweep()
+	c.codes[code.Code] = code
+	return nil
+}
+
+// TakeCode implements OAuth2Store
+func (c *MemoryOAuth2Store) TakeCode(code string) (*OAuth2Code, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if res, ok := c.codes[code]; ok {
+		delete(c.codes, code)
+		return &res, nil
+	}
+	return nil, nil
+}
+
+// PutToken implements OAuth2Store
+func (c *MemoryOAuth2Store) PutToken(token OAuth2Token) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sweep()
+	c.access[token.AccessToken] = token
+	c.refresh[token.RefreshToken] = token.AccessToken
+	return nil
+}
+
+// AccessToken implements OAuth2Store
+func (c *MemoryOAuth2Store) AccessToken(token string) (*OAuth2Token, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if res, ok := c.access[token]; ok {
+		return &res, nil
+	}
+	return nil, nil
+}
+
+// RefreshToken implements OAuth2Store
+func (c *MemoryOAuth2Store) RefreshToken(token string) (*OAuth2Token, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if access, ok := c.refresh[token]; ok {
+		res := c.access[access]
+		return &res, nil
+	}
+	return nil, nil
+}
+
+// TakeRefreshToken implements OAuth2Store
+func (c *MemoryOAuth2Store) TakeRefreshToken(token string) (*OAuth2Token, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	access, ok := c.refresh[token]
+	if !ok {
+		return nil, nil
+	}
+	res := c.access[access]
+	delete(c.access, access)
+	delete(c.refresh, token)
+	return &res, nil
+}
+
+// RemoveToken implements OAuth2Store
+func (c *MemoryOAuth2Store) RemoveToken(token OAuth2Token) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.access, token.AccessToken)
+	delete(c.refresh, token.RefreshToken)
+	return nil
+}
+
+// isOAuth2Path returns whether path is served by the OAuth2Provider
+func isOAuth2Path(path string) bool {
+	return strings.HasPrefix(path, "/oauth2/")
+}
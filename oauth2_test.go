@@ -0,0 +1,215 @@
+package ifttt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOAuth2Users map[string]UserInfo
+
+func (c testOAuth2Users) UserInfo(userid string) (*UserInfo, error) {
+	info := c[userid]
+	return &info, nil
+}
+
+func TestOAuth2Provider(t *testing.T) {
+	client := OAuth2Client{"ifttt", "clientsecret", []string{"https://ifttt.com/channels/test/authorize"}}
+	provider := &OAuth2Provider{
+		Store: NewMemoryOAuth2Store(client, OAuth2Client{"other", "othersecret", nil}),
+		Users: testOAuth2Users{"alice": {Name: "Alice", ID: "alice"}},
+		Consent: func(w http.ResponseWriter, r *http.Request, req *OAuth2AuthorizeRequest) (string, error) {
+			switch r.Form.Get("login") {
+			case "alice":
+				return "alice", nil
+			case "deny":
+				return "", ErrorOAuth2AccessDenied
+			}
+			w.WriteHeader(200)
+			w.Write([]byte("login page"))
+			return "", nil
+		},
+		TokenTTL: time.Hour,
+	}
+	service := Service{ServiceKey: "servicekey", OAuth2: provider}
+
+	do := func(method string, target string, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if method == "POST" && strings.HasPrefix(target, "/oauth2/") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for key, val := range header {
+			req.Header.Set(key, val)
+		}
+		res := httptest.NewRecorder()
+		service.ServeHTTP(res, req)
+		return res
+	}
+	authorize := "/oauth2/authorize?response_type=code&client_id=ifttt&state=xyz&redirect_uri=" + url.QueryEscape(client.RedirectURIs[0])
+	exchange := func(form url.Values) map[string]interface{} {
+		res := do("POST", "/oauth2/token", form.Encode(), nil)
+		decoded := make(map[string]interface{})
+		json.Unmarshal(res.Body.Bytes(), &decoded)
+		decoded["status"] = res.Code
+		return decoded
+	}
+
+	if res := do("GET", authorize, "", nil); res.Code != 200 || res.Body.String() != "login page" {
+		t.Errorf("Expected login page: %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+	if res := do("GET", strings.Replace(authorize, "ifttt.com", "evil.com", 1)+"&login=alice", "", nil); res.Code != 400 {
+		t.Errorf("Expected refusal of unregistered redirect uri: %d\n", res.Code)
+		t.Fail()
+	}
+	if res := do("GET", authorize+"&login=deny", "", nil); res.Code != 302 || !strings.Contains(res.Header().Get("Location"), "error=access_denied") {
+		t.Errorf("Expected access denied redirect: %d %s\n", res.Code, res.Header().Get("Location"))
+		t.Fail()
+	}
+
+	res := do("GET", authorize+"&login=alice", "", nil)
+	location, _ := url.Parse(res.Header().Get("Location"))
+	code := location.Query().Get("code")
+	if res.Code != 302 || code == "" || location.Query().Get("state") != "xyz" {
+		t.Fatalf("Unexpected authorize response: %d %s\n", res.Code, location)
+	}
+
+	// the redirect URI is required and must match the one the code was sent to
+	res = do("GET", authorize+"&login=alice", "", nil)
+	location, _ = url.Parse(res.Header().Get("Location"))
+	for _, redirect := range []string{"", "https://example.com/callback"} {
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {location.Query().Get("code")}, "redirect_uri": {redirect}, "client_id": {"ifttt"}, "client_secret": {"clientsecret"}}
+		if token := exchange(form); token["status"] != 400 || token["error"] != "invalid_grant" {
+			t.Errorf("Expected invalid grant with redirect_uri %q: %v\n", redirect, token)
+			t.Fail()
+		}
+	}
+
+	// the redirect URI may be omitted if it was omitted from the authorization request
+	res = do("GET", strings.Replace(authorize, "&redirect_uri=", "&omitted=", 1)+"&login=alice", "", nil)
+	location, _ = url.Parse(res.Header().Get("Location"))
+	if token := exchange(url.Values{"grant_type": {"authorization_code"}, "code": {location.Query().Get("code")}, "client_id": {"ifttt"}, "client_secret": {"clientsecret"}}); token["status"] != 200 {
+		t.Errorf("Expected token without redirect_uri: %v\n", token)
+		t.Fail()
+	}
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {client.RedirectURIs[0]}, "client_id": {"ifttt"}, "client_secret": {"wrongsecret"}}
+	if token := exchange(form); token["status"] != 401 || token["error"] != "invalid_client" {
+		t.Errorf("Expected invalid client: %v\n", token)
+		t.Fail()
+	}
+	form.Set("client_secret", "clientsecret")
+	token := exchange(form)
+	if token["status"] != 200 || token["token_type"] != "Bearer" || token["expires_in"] != 3600.0 {
+		t.Fatalf("Unexpected token response: %v\n", token)
+	}
+	if reused := exchange(form); reused["status"] != 400 || reused["error"] != "invalid_grant" {
+		t.Errorf("Expected code to be single use: %v\n", reused)
+		t.Fail()
+	}
+
+	userInfo := func(accessToken interface{}) *httptest.ResponseRecorder {
+		return do("GET", "/ifttt/v1/user/info", "", map[string]string{
+			"Authorization":     "Bearer " + accessToken.(string),
+			"IFTTT-Service-Key": "servicekey",
+		})
+	}
+	if res := userInfo(token["access_token"]); res.Code != 200 || !jsonEqual(res.Body.Bytes(), []byte(`{"data":{"name":"Alice","id":"alice"}}`)) {
+		t.Errorf("Unexpected user info: %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+	if res := userInfo("unknowntoken"); res.Code != 401 {
+		t.Errorf("Expected unknown token to be refused: %d\n", res.Code)
+		t.Fail()
+	}
+
+	refreshed := exchange(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token["refresh_token"].(string)}, "client_id": {"ifttt"}, "client_secret": {"clientsecret"}})
+	if refreshed["status"] != 200 || refreshed["access_token"] == token["access_token"] {
+		t.Fatalf("Unexpected refresh response: %v\n", refreshed)
+	}
+	if res := userInfo(token["access_token"]); res.Code != 401 {
+		t.Errorf("Expected rotated token to be refused: %d\n", res.Code)
+		t.Fail()
+	}
+	if reused := exchange(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token["refresh_token"].(string)}, "client_id": {"ifttt"}, "client_secret": {"clientsecret"}}); reused["status"] != 400 {
+		t.Errorf("Expected rotated refresh token to be refused: %v\n", reused)
+		t.Fail()
+	}
+	if res := userInfo(refreshed["access_token"]); res.Code != 200 {
+		t.Errorf("Expected refreshed token to be accepted: %d\n", res.Code)
+		t.Fail()
+	}
+
+	// refresh tokens of other clients are refused without being consumed or revoked
+	revoked := 0
+	provider.Revoked = func(token OAuth2Token) {
+		revoked++
+	}
+	if stolen := exchange(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshed["refresh_token"].(string)}, "client_id": {"other"}, "client_secret": {"othersecret"}}); stolen["status"] != 400 || stolen["error"] != "invalid_grant" {
+		t.Errorf("Expected refresh token of another client to be refused: %v\n", stolen)
+		t.Fail()
+	}
+	if res := userInfo(refreshed["access_token"]); res.Code != 200 || revoked != 0 {
+		t.Errorf("Expected refresh token of another client to be kept: %d %d\n", res.Code, revoked)
+		t.Fail()
+	}
+	provider.Revoked = nil
+
+	revoke := url.Values{"token": {refreshed["refresh_token"].(string)}, "client_id": {"ifttt"}, "client_secret": {"clientsecret"}}
+	if res := do("POST", "/oauth2/revoke", revoke.Encode(), nil); res.Code != 200 {
+		t.Errorf("Unexpected revoke response: %d\n", res.Code)
+		t.Fail()
+	}
+	if res := userInfo(refreshed["access_token"]); res.Code != 401 {
+		t.Errorf("Expected revoked token to be refused: %d\n", res.Code)
+		t.Fail()
+	}
+}
+
+func TestOAuth2TokenExpiry(t *testing.T) {
+	store := NewMemoryOAuth2Store()
+	provider := &OAuth2Provider{Store: store}
+	store.PutToken(OAuth2Token{AccessToken: "expired", RefreshToken: "r1", Expires: time.Now().Add(-time.Second)})
+	store.PutToken(OAuth2Token{AccessToken: "forever", RefreshToken: "r2", UserID: "bob"})
+
+	if _, err := provider.Resolve("expired"); err == nil {
+		t.Errorf("Expected expired token to be refused\n")
+		t.Fail()
+	}
+	if token, err := provider.Resolve("forever"); err != nil || token.UserID != "bob" {
+		t.Errorf("Unexpected token: %v %v\n", token, err)
+		t.Fail()
+	}
+}
+
+func TestMemoryOAuth2StoreSweep(t *testing.T) {
+	store := NewMemoryOAuth2Store()
+	store.PutCode(OAuth2Code{Code: "old", Expires: time.Now().Add(-time.Second)})
+	store.PutToken(OAuth2Token{AccessToken: "a1", RefreshToken: "r1", RefreshExpires: time.Now().Add(-time.Second)})
+	store.PutToken(OAuth2Token{AccessToken: "a2", RefreshToken: "r2", Expires: time.Now().Add(-time.Second)})
+	store.lastSweep = time.Now().Add(-time.Hour)
+	store.PutCode(OAuth2Code{Code: "new", Expires: time.Now().Add(time.Minute)})
+
+	if len(store.codes) != 1 || len(store.access) != 1 || len(store.refresh) != 1 {
+		t.Errorf("Unexpected store after sweep: %v %v %v\n", store.codes, store.access, store.refresh)
+		t.Fail()
+	}
+
+	// a refresh token can only be taken once
+	if token, _ := store.TakeRefreshToken("r2"); token == nil || token.AccessToken != "a2" {
+		t.Fatalf("Unexpected token: %v\n", token)
+	}
+	if token, _ := store.TakeRefreshToken("r2"); token != nil {
+		t.Errorf("Expected refresh token to be taken once: %v\n", token)
+		t.Fail()
+	}
+	if token, _ := store.AccessToken("a2"); token != nil {
+		t.Errorf("Expected access token to be removed with its refresh token: %v\n", token)
+		t.Fail()
+	}
+}
@@ -0,0 +1,225 @@
+package ifttt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedTrigger can be optionally implemented by a Trigger to have the results of Poll cached in Service.PollCache
+type CachedTrigger interface {
+	// PollCacheTTL returns how long the events of a poll are reused for polls of the same trigger identity, fields and limit, 0 to disable caching
+	PollCacheTTL() time.Duration
+}
+
+// PollInvalidator keeps state derived from the polls of trigger identities, such as PollCache and RateLimiter
+// Add it to Service.PollInvalidators to have it invalidated along with Service.PollCache.
+type PollInvalidator interface {
+	// Invalidate drops the state of trigger identities
+	Invalidate(identities ...string)
+	// InvalidateUser drops the state of the trigger identities polled by users
+	InvalidateUser(userIDs ...string)
+}
+
+type pollCacheEntry struct {
+	events  TriggerEventCollection
+	expires time.Time
+}
+
+// pollCall is a poll in flight shared by concurrent identical polls
+type pollCall struct {
+	done     chan struct{}
+	identity string
+	events   TriggerEventCollection
+	err      error
+	// stale is set if the identity was invalidated while the poll was in flight
+	stale bool
+}
+
+// PollCache caches the events returned by triggers which implement CachedTrigger, keyed by trigger identity, trigger fields and limit
+// Concurrent identical polls are coalesced into a single call to Poll, errors are never cached.
+// Cached events of a trigger identity are dropped when it is notified by Service.Notify (directly or through the user owning it) or deleted by IFTTT.
+type PollCache struct {
+	lock      sync.Mutex
+	entries   map[string]map[string]pollCacheEntry
+	calls     map[string]*pollCall
+	users     map[string]map[string]bool
+	lastSweep time.Time
+}
+
+// NewPollCache creates an empty PollCache
+func NewPollCache() *PollCache {
+	return &PollCache{
+		entries:   make(map[string]map[string]pollCacheEntry),
+		calls:     make(map[string]*pollCall),
+		users:     make(map[string]map[string]bool),
+		lastSweep: time.Now(),
+	}
+}
+
+// pollCacheKey returns the key of a poll within its trigger identity
+func pollCacheKey(slug string, tpr *TriggerPollRequest) string {
+	keys := make([]string, 0, len(tpr.TriggerFields))
+	for key := range tpr.TriggerFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{slug, strconv.Itoa(tpr.Limit)}
+	for _, key := range keys {
+		parts = append(parts, key, tpr.TriggerFields[key])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// sweep drops expired entries at most once per minute, c.lock must be held
+func (c *PollCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	for identity, entries := range c.entries {
+		for key, entry := range entries {
+			if now.After(entry.expires) {
+				delete(entries, key)
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.entries, identity)
+		}
+	}
+	for userID, identities := range c.users {
+		for identity := range identities {
+			if _, ok := c.entries[identity]; !ok {
+				delete(identities, identity)
+			}
+		}
+		if len(identities) == 0 {
+			delete(c.users, userID)
+		}
+	}
+	c.lastSweep = now
+}
+
+// copyEvents returns a copy of events, as limit and marshal sort them in place
+func copyEvents(events TriggerEventCollection) TriggerEventCollection {
+	if events == nil {
+		return nil
+	}
+	res := make(TriggerEventCollection, len(events))
+	copy(res, events)
+	return res
+}
+
+// canceled returns whether err is caused by the cancellation or the deadline of a context
+func canceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// poll returns the cached events of the poll, or calls fetch once for all concurrent identical polls and caches its result for ttl
+// Concurrent polls wait for the result of the first one until ctx is done. If the first poll failed because its own context was canceled,
+// the waiters whose ctx is still alive poll again instead of sharing its error.
+func (c *PollCache) poll(ctx context.Context, identity string, key string, userID string, ttl time.Duration, fetch func() (TriggerEventCollection, error)) (TriggerEventCollection, error) {
+	callKey := identity + "\x00" + key
+	for {
+		now := time.Now()
+		c.lock.Lock()
+		c.sweep(now)
+		if entry, ok := c.entries[identity][key]; ok && now.Before(entry.expires) {
+			c.lock.Unlock()
+			return copyEvents(entry.events), nil
+		}
+		call, ok := c.calls[callKey]
+		if !ok {
+			break
+		}
+		c.lock.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if canceled(call.err) && ctx.Err() == nil {
+			continue
+		}
+		return copyEvents(call.events), call.err
+	}
+	call := &pollCall{done: make(chan struct{}), identity: identity}
+	c.calls[callKey] = call
+	c.lock.Unlock()
+
+	completed := false
+	defer func() {
+		if !completed {
+			call.err = ErrorPanicDuringProcess
+		}
+		c.lock.Lock()
+		delete(c.calls, callKey)
+		if call.err == nil && !call.stale {
+			if c.entries[identity] == nil {
+				c.entries[identity] = make(map[string]pollCacheEntry)
+			}
+			c.entries[identity][key] = pollCacheEntry{copyEvents(call.events), time.Now().Add(ttl)}
+			if userID != "" {
+				if c.users[userID] == nil {
+					c.users[userID] = make(map[string]bool)
+				}
+				c.users[userID][identity] = true
+			}
+		}
+		c.lock.Unlock()
+		close(call.done)
+	}()
+	call.events, call.err = fetch()
+	completed = true
+	return copyEvents(call.events), call.err
+}
+
+// invalidate drops the entries of identity, c.lock must be held
+func (c *PollCache) invalidate(identity string) {
+	delete(c.entries, identity)
+	for _, call := range c.calls {
+		if call.identity == identity {
+			call.stale = true
+		}
+	}
+}
+
+// Invalidate drops the cached events of trigger identities
+func (c *PollCache) Invalidate(identities ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, identity := range identities {
+		c.invalidate(identity)
+	}
+}
+
+// InvalidateUser drops the cached events of the trigger identities polled by a user
+// Users are only known for polls of authenticated requests, see Request.UserID.
+func (c *PollCache) InvalidateUser(userIDs ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, userID := range userIDs {
+		for identity := range c.users[userID] {
+			c.invalidate(identity)
+		}
+		delete(c.users, userID)
+	}
+}
+
+// cachedPoll calls Poll through Service.PollCache if the trigger implements CachedTrigger
+func (c *Service) cachedPoll(ctx context.Context, trigger TriggerWithContext, tpr *TriggerPollRequest, req *Request) (TriggerEventCollection, error) {
+	cached, ok := unwrapHandler(trigger).(CachedTrigger)
+	if !ok || c.PollCache == nil || tpr.TriggerIdentity == "" {
+		return trigger.PollWithContext(ctx, tpr, req)
+	}
+	ttl := cached.PollCacheTTL()
+	if ttl <= 0 {
+		return trigger.PollWithContext(ctx, tpr, req)
+	}
+	return c.PollCache.poll(ctx, tpr.TriggerIdentity, pollCacheKey(req.Slug, tpr), req.UserID, ttl, func() (TriggerEventCollection, error) {
+		return trigger.PollWithContext(ctx, tpr, req)
+	})
+}
@@ -0,0 +1,217 @@
+package ifttt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testCachedTrigger struct {
+	polls *int32
+	ttl   time.Duration
+}
+
+func (c testCachedTrigger) RealTime() bool {
+	return false
+}
+
+func (c testCachedTrigger) PollCacheTTL() time.Duration {
+	return c.ttl
+}
+
+func (c testCachedTrigger) Poll(req *TriggerPollRequest, r *Request) (TriggerEventCollection, error) {
+	n := atomic.AddInt32(c.polls, 1)
+	evt := TriggerEvent{Ingredients: map[string]string{"n": strconv.Itoa(int(n))}}
+	evt.Meta.ID = strconv.Itoa(int(n))
+	evt.Meta.Time = time.Unix(int64(n), 0)
+	return TriggerEventCollection{evt}, nil
+}
+
+func (c testCachedTrigger) Options(req *Request) (*DynamicOption, error) {
+	return nil, nil
+}
+
+func (c testCachedTrigger) ValidateField(fieldslug string, value string, req *Request) error {
+	return nil
+}
+
+func (c testCachedTrigger) ValidateContext(values map[string]string, req *Request) (map[string]error, error) {
+	return nil, nil
+}
+
+func (c testCachedTrigger) RemoveIdentity(triggerid string) error {
+	return nil
+}
+
+func TestPollCache(t *testing.T) {
+	realtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer realtime.Close()
+
+	var polls int32
+	service := &Service{
+		ServiceKey:  "servicekey",
+		RealtimeURL: realtime.URL,
+		PollCache:   NewPollCache(),
+		Authenticator: AuthenticatorFunc(func(ctx context.Context, token string, req *Request) (*Principal, error) {
+			return &Principal{ID: token}, nil
+		}),
+	}
+	service.RegisterTrigger("cached", testCachedTrigger{&polls, time.Hour})
+	service.RegisterTrigger("short", testCachedTrigger{&polls, 20 * time.Millisecond})
+
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("IFTTT-Service-Key", "servicekey")
+		req.Header.Set("Authorization", "Bearer alice")
+		res := httptest.NewRecorder()
+		service.ServeHTTP(res, req)
+		return res
+	}
+	poll := func(slug string, identity string, fields string) {
+		if res := do("POST", "/ifttt/v1/triggers/"+slug, `{"trigger_identity":"`+identity+`","triggerFields":{`+fields+`}}`); res.Code != 200 {
+			t.Fatalf("Unexpected poll response: %d %s\n", res.Code, res.Body.String())
+		}
+	}
+	expect := func(n int32, msg string) {
+		if got := atomic.LoadInt32(&polls); got != n {
+			t.Fatalf("%s: expected %d calls to Poll, got %d\n", msg, n, got)
+		}
+	}
+
+	poll("cached", "a", `"foo":"bar"`)
+	poll("cached", "a", `"foo":"bar"`)
+	expect(1, "identical polls")
+	poll("cached", "a", `"foo":"baz"`)
+	poll("cached", "b", `"foo":"bar"`)
+	expect(3, "polls of other fields and identities")
+
+	evt := Notification{}
+	evt.AddTrigger("a")
+	if err := service.Notify(evt); err != nil {
+		t.Fatalf("Unexpected notify error: %v\n", err)
+	}
+	poll("cached", "a", `"foo":"bar"`)
+	poll("cached", "b", `"foo":"bar"`)
+	expect(4, "poll of notified identity")
+
+	evt = Notification{}
+	evt.AddUser("alice")
+	service.Notify(evt)
+	poll("cached", "b", `"foo":"bar"`)
+	expect(5, "poll of notified user")
+
+	if res := do("DELETE", "/ifttt/v1/triggers/cached/trigger_identity/b", ""); res.Code != 200 {
+		t.Fatalf("Unexpected delete response: %d\n", res.Code)
+	}
+	poll("cached", "b", `"foo":"bar"`)
+	expect(6, "poll of deleted identity")
+
+	poll("short", "c", "")
+	time.Sleep(30 * time.Millisecond)
+	poll("short", "c", "")
+	expect(8, "poll after expiry")
+}
+
+func TestPollCacheCoalescing(t *testing.T) {
+	cache := NewPollCache()
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (TriggerEventCollection, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return TriggerEventCollection{}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.poll(context.Background(), "a", "key", "", time.Hour, fetch); err != nil {
+				t.Errorf("Unexpected error: %v\n", err)
+			}
+		}()
+	}
+	// wait for the first poll to be in flight
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// results of polls in flight when the identity is invalidated are not cached
+	cache.Invalidate("a")
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("Expected concurrent polls to be coalesced, got %d calls\n", calls)
+	}
+	cache.poll(context.Background(), "a", "key", "", time.Hour, fetch)
+	if calls != 2 {
+		t.Fatalf("Expected stale result not to be cached, got %d calls\n", calls)
+	}
+
+	// errors are not cached
+	failing := func() (TriggerEventCollection, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("upstream down")
+	}
+	cache.poll(context.Background(), "b", "key", "", time.Hour, failing)
+	if _, err := cache.poll(context.Background(), "b", "key", "", time.Hour, failing); err == nil || calls != 4 {
+		t.Fatalf("Expected errors not to be cached, got %v after %d calls\n", err, calls)
+	}
+}
+
+func TestPollCacheCanceledWaiters(t *testing.T) {
+	cache := NewPollCache()
+	var calls int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	failing := func() (TriggerEventCollection, error) {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	fetch := func() (TriggerEventCollection, error) {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-release
+		return TriggerEventCollection{}, nil
+	}
+
+	first := make(chan error)
+	go func() {
+		_, err := cache.poll(ctx, "a", "key", "", time.Hour, failing)
+		first <- err
+	}()
+	<-started
+	// a waiter whose context is done stops waiting
+	waiterCtx, waiterCancel := context.WithCancel(context.Background())
+	waiterCancel()
+	if _, err := cache.poll(waiterCtx, "a", "key", "", time.Hour, fetch); err != context.Canceled {
+		t.Fatalf("Expected the canceled waiter to give up, got %v\n", err)
+	}
+	// a waiter whose context is alive polls again when the first poll is canceled
+	second := make(chan error)
+	go func() {
+		_, err := cache.poll(context.Background(), "a", "key", "", time.Hour, fetch)
+		second <- err
+	}()
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("Expected the first poll to be canceled, got %v\n", err)
+	}
+	<-started
+	close(release)
+	if err := <-second; err != nil || calls != 2 {
+		t.Fatalf("Expected the waiter to poll again, got %v after %d calls\n", err, calls)
+	}
+}
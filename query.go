@@ -0,0 +1,126 @@
+package ifttt
+
+import (
+	"context"
+
+	"github.com/Jeffail/gabs"
+)
+
+// QueryRequest represents the request from IFTTT to perform a query
+// https://platform.ifttt.com/docs/api_reference#queries
+type QueryRequest struct {
+	// QueryFields the values of the query fields
+	QueryFields map[string]string
+	// Fields are the decoded values of QueryFields, typed according to the FieldSchema if the query implements SchemaProvider
+	Fields FieldValues
+	// Limit max number of items requested, you can return a little more but extra items will be ignored
+	Limit int
+	// Cursor the cursor returned in the previous page of this query, empty string if requesting the first page
+	Cursor string
+	// User contains the metadata of the IFTTT user (eg: timezone)
+	User map[string]string
+	// UserContext contains the timezone of the IFTTT user resolved from User
+	UserContext UserContext
+	// Source describes the applet which initiated this request
+	Source Source
+	// Metadata contains other top level keys of the request body sent by IFTTT
+	Metadata map[string]interface{}
+}
+
+// QueryItem represents a single item returned by a query
+type QueryItem struct {
+	// Ingredients contains values of the ingredients of this item
+	Ingredients map[string]string
+}
+
+// QueryResult represents a page of items returned by a query
+type QueryResult struct {
+	// Items the items in this page
+	Items []QueryItem
+	// Cursor an opaque string passed back by IFTTT to request the next page, leave empty if there are no more items
+	Cursor string
+}
+
+// limit drops the items after the first n, n < 0 means no limit
+func (c *QueryResult) limit(n int) *QueryResult {
+	if c != nil && n >= 0 && n < len(c.Items) {
+		c.Items = c.Items[:n]
+	}
+	return c
+}
+
+func (c *QueryResult) marshal() []byte {
+	res := gabs.New()
+	res.Array("data")
+	for _, item := range c.Items {
+		obj := gabs.New()
+		obj.Object()
+		for key, val := range item.Ingredients {
+			obj.Set(val, key)
+		}
+		res.ArrayAppend(obj.Data(), "data")
+	}
+	if len(c.Cursor) > 0 {
+		res.Set(c.Cursor, "cursor")
+	}
+	return res.Bytes()
+}
+
+// Query is the interface which every registered query should implement.
+type Query interface {
+	// Query is called when an applet performs this query
+	// Implementations should return at most req.Limit items starting from req.Cursor, and set the Cursor of the result if more items are available.
+	Query(req *QueryRequest, r *Request) (*QueryResult, error)
+	// Options is called during a query dynamic option request
+	// Use req.FieldSlug for the field requested by this request.
+	// If your query does not contain dynamic options, return nil, nil here.
+	Options(req *Request) (*DynamicOption, error)
+	// ValidateField is called during a query single-field validation request
+	// If the field value is unacceptable, return a non-nil error which contains a friendly message describing the problem
+	ValidateField(fieldslug string, value string, req *Request) error
+	// ValidateContext is called during a query field context validation request
+	// You need to contact IFTTT first to have this feature enabled
+	// If you do not use this feature, return nil, nil here.
+	ValidateContext(values map[string]string, req *Request) (map[string]error, error)
+}
+
+// QueryWithContext is the context-aware variant of Query.
+// Register it with Service.RegisterQueryWithContext.
+type QueryWithContext interface {
+	// QueryWithContext is the context-aware variant of Query.Query
+	QueryWithContext(ctx context.Context, req *QueryRequest, r *Request) (*QueryResult, error)
+	// OptionsWithContext is the context-aware variant of Query.Options
+	OptionsWithContext(ctx context.Context, req *Request) (*DynamicOption, error)
+	// ValidateFieldWithContext is the context-aware variant of Query.ValidateField
+	ValidateFieldWithContext(ctx context.Context, fieldslug string, value string, req *Request) error
+	// ValidateContextWithContext is the context-aware variant of Query.ValidateContext
+	ValidateContextWithContext(ctx context.Context, values map[string]string, req *Request) (map[string]error, error)
+}
+
+// queryAdapter adapts a Query to QueryWithContext by ignoring the context
+type queryAdapter struct {
+	Query
+}
+
+func (c queryAdapter) QueryWithContext(ctx context.Context, req *QueryRequest, r *Request) (*QueryResult, error) {
+	return c.Query.Query(req, r)
+}
+
+func (c queryAdapter) OptionsWithContext(ctx context.Context, req *Request) (*DynamicOption, error) {
+	return c.Options(req)
+}
+
+func (c queryAdapter) ValidateFieldWithContext(ctx context.Context, fieldslug string, value string, req *Request) error {
+	return c.ValidateField(fieldslug, value, req)
+}
+
+func (c queryAdapter) ValidateContextWithContext(ctx context.Context, values map[string]string, req *Request) (map[string]error, error) {
+	return c.ValidateContext(values, req)
+}
+
+// QuerySampler can be optionally implemented by a Query to declare the sample values used in the IFTTT endpoint tests.
+// If Service.TestSetup is nil, these samples are collected automatically from registered queries.
+type QuerySampler interface {
+	// SampleFields returns query field values which would make a valid query
+	SampleFields() map[string]string
+}
@@ -0,0 +1,28 @@
+package ifttt
+
+import "testing"
+
+func TestMarshalQueryResult(t *testing.T) {
+	res := QueryResult{}
+	if res := res.marshal(); !jsonEqual(res, []byte(`{"data":[]}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
+
+	res = QueryResult{
+		Items: []QueryItem{
+			{Ingredients: map[string]string{"foo": "bar"}},
+			{Ingredients: map[string]string{"foo": "baz"}},
+		},
+		Cursor: "2",
+	}
+	if res := res.marshal(); !jsonEqual(res, []byte(`{"data":[{"foo":"bar"},{"foo":"baz"}],"cursor":"2"}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
+
+	if res := res.limit(1).marshal(); !jsonEqual(res, []byte(`{"data":[{"foo":"bar"}],"cursor":"2"}`)) {
+		t.Errorf("MarshalError: Unexpected JSON after limit: %s\n", res)
+		t.Fail()
+	}
+}
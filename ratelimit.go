@@ -0,0 +1,423 @@
+package ifttt
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket refilled with Requests tokens every Per, holding at most Burst tokens
+// The zero value does not limit requests.
+type RateLimit struct {
+	// Requests the number of requests allowed every Per
+	Requests int
+	// Per the interval the bucket is refilled over
+	Per time.Duration
+	// Burst the capacity of the bucket, defaults to Requests
+	Burst int
+}
+
+func (c RateLimit) unlimited() bool {
+	return c.Requests <= 0 || c.Per <= 0
+}
+
+func (c RateLimit) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return float64(c.Requests)
+}
+
+// rate returns the number of tokens added per second
+func (c RateLimit) rate() float64 {
+	return float64(c.Requests) / c.Per.Seconds()
+}
+
+// full returns the time it takes the bucket to refill completely
+func (c RateLimit) full() time.Duration {
+	return time.Duration(c.burst() / c.rate() * float64(time.Second))
+}
+
+// RateLimitBucket is the state of a token bucket
+type RateLimitBucket struct {
+	// Tokens the tokens left in the bucket at Updated
+	Tokens float64
+	// Updated the time the bucket was last refilled
+	Updated time.Time
+}
+
+// take refills the bucket at now and takes a token, returning the wait until a token is available if the bucket is empty
+func (c *RateLimitBucket) take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	if c.Updated.IsZero() {
+		c.Tokens = limit.burst()
+	} else if elapsed := now.Sub(c.Updated); elapsed > 0 {
+		c.Tokens = math.Min(limit.burst(), c.Tokens+elapsed.Seconds()*limit.rate())
+	}
+	c.Updated = now
+	if c.Tokens >= 1 {
+		c.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - c.Tokens) / limit.rate() * float64(time.Second))
+}
+
+// RateLimitStore keeps the token buckets of a RateLimiter
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, returning the wait until a token is available if the bucket is empty
+	Take(key string, limit RateLimit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// MemoryRateLimitStore is a RateLimitStore which keeps buckets in memory, it is not shared between processes
+type MemoryRateLimitStore struct {
+	lock      sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	RateLimitBucket
+	full time.Duration
+}
+
+// NewMemoryRateLimitStore creates an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*memoryBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// sweep drops buckets which have refilled completely at most once per minute, c.lock must be held
+func (c *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	for key, bucket := range c.buckets {
+		if now.Sub(bucket.Updated) > bucket.full {
+			delete(c.buckets, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+// Take implements RateLimitStore
+func (c *MemoryRateLimitStore) Take(key string, limit RateLimit) (bool, time.Duration, error) {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sweep(now)
+	bucket, ok := c.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{}
+		c.buckets[key] = bucket
+	}
+	bucket.full = limit.full()
+	allowed, wait := bucket.take(limit, now)
+	return allowed, wait, nil
+}
+
+// RateLimitBackend is a key-value store shared between processes with compare-and-swap, eg: Redis WATCH/MULTI, memcached CAS or etcd transactions
+type RateLimitBackend interface {
+	// Get returns the value of key, nil if it does not exist
+	Get(key string) ([]byte, error)
+	// CompareAndSwap sets key to value with an expiry of ttl if its current value is still old (nil meaning absent), returning false otherwise
+	CompareAndSwap(key string, old []byte, value []byte, ttl time.Duration) (bool, error)
+}
+
+// ErrorRateLimitContention is returned by SharedRateLimitStore when a bucket kept changing during all its attempts
+var ErrorRateLimitContention = errors.New("Rate limit bucket changed concurrently too many times")
+
+// SharedRateLimitStore is a RateLimitStore which keeps buckets in a RateLimitBackend so limits apply across processes
+type SharedRateLimitStore struct {
+	// Backend the shared key-value store
+	Backend RateLimitBackend
+	// Prefix is prepended to the keys of buckets
+	Prefix string
+	// Attempts the number of compare-and-swap attempts before giving up with ErrorRateLimitContention, defaults to 10
+	Attempts int
+}
+
+func encodeBucket(bucket RateLimitBucket) []byte {
+	return []byte(strconv.FormatFloat(bucket.Tokens, 'g', -1, 64) + " " + strconv.FormatInt(bucket.Updated.UnixNano(), 10))
+}
+
+func decodeBucket(data []byte) (RateLimitBucket, error) {
+	parts := strings.SplitN(string(data), " ", 2)
+	if len(parts) != 2 {
+		return RateLimitBucket{}, errors.New("Malformed rate limit bucket")
+	}
+	tokens, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return RateLimitBucket{}, err
+	}
+	updated, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return RateLimitBucket{}, err
+	}
+	return RateLimitBucket{tokens, time.Unix(0, updated)}, nil
+}
+
+// Take implements RateLimitStore
+func (c *SharedRateLimitStore) Take(key string, limit RateLimit) (bool, time.Duration, error) {
+	attempts := c.Attempts
+	if attempts <= 0 {
+		attempts = 10
+	}
+	key = c.Prefix + key
+	for i := 0; i < attempts; i++ {
+		old, err := c.Backend.Get(key)
+		if err != nil {
+			return false, 0, err
+		}
+		var bucket RateLimitBucket
+		if old != nil {
+			if bucket, err = decodeBucket(old); err != nil {
+				return false, 0, err
+			}
+		}
+		allowed, wait := bucket.take(limit, time.Now())
+		swapped, err := c.Backend.CompareAndSwap(key, old, encodeBucket(bucket), limit.full())
+		if err != nil {
+			return false, 0, err
+		}
+		if swapped {
+			return allowed, wait, nil
+		}
+	}
+	return false, 0, ErrorRateLimitContention
+}
+
+// RateLimitKey returns the key requests are limited by, requests with an empty key are not limited
+type RateLimitKey func(req *Request) string
+
+// RateLimitByToken limits requests by user access token
+func RateLimitByToken(req *Request) string {
+	return req.UserAccessToken
+}
+
+// RateLimitByTriggerIdentity limits requests by trigger identity, requests without a trigger identity are not limited
+func RateLimitByTriggerIdentity(req *Request) string {
+	return req.TriggerIdentity
+}
+
+// RateLimitBySlug limits all requests to a slug together
+func RateLimitBySlug(req *Request) string {
+	return req.Slug
+}
+
+// RateLimiter limits the rate of requests with token buckets, add it to a Service with Use(limiter.Middleware())
+// Limited actions and other requests are answered with 429 and Retry-After,
+// limited trigger polls with the last response of the trigger identity, or an empty event list if there is none, so IFTTT does not report errors.
+// Add it to Service.PollInvalidators as well so last responses are dropped when their trigger identity or its user is notified, or IFTTT deletes the identity.
+type RateLimiter struct {
+	// Store keeps the buckets, defaults to a MemoryRateLimitStore
+	Store RateLimitStore
+	// Key returns the key requests are limited by, defaults to RateLimitByToken
+	// Each slug and request type has its own buckets.
+	Key RateLimitKey
+	// Default the limit of requests whose slug and type are absent from Slugs and Types
+	Default RateLimit
+	// Slugs the limits of requests by slug
+	Slugs map[string]RateLimit
+	// Types the limits of requests by request type, used if the slug is absent from Slugs
+	Types map[RequestType]RateLimit
+	// PollTTL how long the last response of a trigger identity is replayed to its limited polls, defaults to 1 hour
+	PollTTL time.Duration
+
+	lock      sync.Mutex
+	store     RateLimitStore
+	polls     map[string]map[string]lastPoll
+	users     map[string]map[string]bool
+	lastSweep time.Time
+}
+
+// lastPoll is the last successful response of a trigger identity to a poll of a slug and limit
+type lastPoll struct {
+	res     *Response
+	expires time.Time
+}
+
+// limit returns the limit applying to req
+func (c *RateLimiter) limit(req *Request) RateLimit {
+	if limit, ok := c.Slugs[req.Slug]; ok {
+		return limit
+	}
+	if limit, ok := c.Types[req.Type]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+func (c *RateLimiter) getStore() RateLimitStore {
+	if c.Store != nil {
+		return c.Store
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.store == nil {
+		c.store = NewMemoryRateLimitStore()
+	}
+	return c.store
+}
+
+// Allow takes a token from the bucket of req, returning the wait until a token is available if the request is limited
+func (c *RateLimiter) Allow(req *Request) (bool, time.Duration, error) {
+	return c.allow(req, c.limit(req))
+}
+
+func (c *RateLimiter) allow(req *Request, limit RateLimit) (bool, time.Duration, error) {
+	if limit.unlimited() {
+		return true, 0, nil
+	}
+	keyFunc := c.Key
+	if keyFunc == nil {
+		keyFunc = RateLimitByToken
+	}
+	key := keyFunc(req)
+	if key == "" {
+		return true, 0, nil
+	}
+	return c.getStore().Take(req.Type.String()+"\x00"+req.Slug+"\x00"+key, limit)
+}
+
+func (c *RateLimiter) pollTTL() time.Duration {
+	if c.PollTTL > 0 {
+		return c.PollTTL
+	}
+	return time.Hour
+}
+
+// sweep drops expired responses at most once per minute, c.lock must be held
+func (c *RateLimiter) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	for identity, polls := range c.polls {
+		for key, poll := range polls {
+			if now.After(poll.expires) {
+				delete(polls, key)
+			}
+		}
+		if len(polls) == 0 {
+			delete(c.polls, identity)
+		}
+	}
+	for userID, identities := range c.users {
+		for identity := range identities {
+			if _, ok := c.polls[identity]; !ok {
+				delete(identities, identity)
+			}
+		}
+		if len(identities) == 0 {
+			delete(c.users, userID)
+		}
+	}
+	c.lastSweep = now
+}
+
+// lastPollKey returns the key of the last response of a poll within its trigger identity, polls with another limit get other responses
+func lastPollKey(req *Request) string {
+	limit := 50
+	if n, ok := req.DecodedBody.S("limit").Data().(float64); ok {
+		limit = int(n)
+	}
+	return req.Slug + "\x00" + strconv.Itoa(limit)
+}
+
+// cloneResponse returns a copy of res which does not share its header and body
+func cloneResponse(res *Response) *Response {
+	copied := *res
+	copied.Header = res.Header.Clone()
+	copied.Body = append([]byte(nil), res.Body...)
+	return &copied
+}
+
+// limitedPoll returns the last response of the polled trigger identity, or an empty event list
+func (c *RateLimiter) limitedPoll(req *Request) *Response {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sweep(now)
+	if poll, ok := c.polls[req.TriggerIdentity][lastPollKey(req)]; ok && now.Before(poll.expires) {
+		return cloneResponse(poll.res)
+	}
+	return &Response{Status: 200, Body: TriggerEventCollection{}.marshal()}
+}
+
+// recordPoll keeps a copy of the last successful response of each trigger identity for PollTTL, as outer middlewares may still modify res
+func (c *RateLimiter) recordPoll(req *Request, res *Response) {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sweep(now)
+	if c.polls == nil {
+		c.polls = make(map[string]map[string]lastPoll)
+		c.users = make(map[string]map[string]bool)
+	}
+	if c.polls[req.TriggerIdentity] == nil {
+		c.polls[req.TriggerIdentity] = make(map[string]lastPoll)
+	}
+	c.polls[req.TriggerIdentity][lastPollKey(req)] = lastPoll{cloneResponse(res), now.Add(c.pollTTL())}
+	if req.UserID != "" {
+		if c.users[req.UserID] == nil {
+			c.users[req.UserID] = make(map[string]bool)
+		}
+		c.users[req.UserID][req.TriggerIdentity] = true
+	}
+}
+
+// Invalidate drops the last responses of trigger identities, implements PollInvalidator
+func (c *RateLimiter) Invalidate(identities ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, identity := range identities {
+		delete(c.polls, identity)
+	}
+}
+
+// InvalidateUser drops the last responses of the trigger identities polled by a user, implements PollInvalidator
+func (c *RateLimiter) InvalidateUser(userIDs ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, userID := range userIDs {
+		for identity := range c.users[userID] {
+			delete(c.polls, identity)
+		}
+		delete(c.users, userID)
+	}
+}
+
+// Middleware returns the Middleware limiting the requests of the service
+// Requests are let through if the store fails, the failure is logged.
+func (c *RateLimiter) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) *Response {
+			limit := c.limit(req)
+			allowed, wait, err := c.allow(req, limit)
+			if err != nil {
+				if req.Logger != nil {
+					req.Logger.Warn("Rate limit store failed", "error", err.Error())
+				}
+				allowed = true
+			}
+			if !allowed {
+				if req.Logger != nil {
+					req.Logger.Info("Request rate limited", "retry_after", wait)
+				}
+				if req.Type == TriggerFetch {
+					return c.limitedPoll(req)
+				}
+				return &Response{Err: RateLimitedError{RetryAfter: wait}}
+			}
+			res := next(ctx, req)
+			if res != nil && res.Err == nil && !limit.unlimited() && req.Type == TriggerFetch {
+				c.recordPoll(req, res)
+			}
+			return res
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package ifttt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/gabs"
+)
+
+type memoryCASBackend struct {
+	lock   sync.Mutex
+	values map[string][]byte
+	fail   bool
+}
+
+func (c *memoryCASBackend) Get(key string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fail {
+		return nil, errors.New("backend down")
+	}
+	return c.values[key], nil
+}
+
+func (c *memoryCASBackend) CompareAndSwap(key string, old []byte, value []byte, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !bytes.Equal(c.values[key], old) || (old == nil && c.values[key] != nil) {
+		return false, nil
+	}
+	c.values[key] = value
+	return true, nil
+}
+
+type testLimitedAction struct{}
+
+func (c testLimitedAction) Options(req *Request) (*DynamicOption, error) {
+	return nil, nil
+}
+
+func (c testLimitedAction) RequiresAuth() bool {
+	return false
+}
+
+func (c testLimitedAction) Handle(r *ActionHandleRequest, req *Request) (*ActionResult, bool, error) {
+	return &ActionResult{ID: "1"}, false, nil
+}
+
+func TestRateLimitBucket(t *testing.T) {
+	limit := RateLimit{Requests: 2, Per: time.Second}
+	bucket := RateLimitBucket{}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 2; i++ {
+		if ok, _ := bucket.take(limit, now); !ok {
+			t.Fatalf("Expected request %d to be allowed\n", i)
+		}
+	}
+	if ok, wait := bucket.take(limit, now); ok || wait != 500*time.Millisecond {
+		t.Fatalf("Expected request to be limited for 500ms, got %v %v\n", ok, wait)
+	}
+	if ok, _ := bucket.take(limit, now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("Expected refilled token\n")
+	}
+	if ok, _ := bucket.take(limit, now.Add(time.Hour)); !ok || bucket.Tokens != 1 {
+		t.Fatalf("Expected bucket capped at burst, got %v\n", bucket.Tokens)
+	}
+}
+
+func TestRateLimitStores(t *testing.T) {
+	limit := RateLimit{Requests: 1, Per: time.Hour, Burst: 2}
+	for name, store := range map[string]RateLimitStore{
+		"memory": NewMemoryRateLimitStore(),
+		"shared": &SharedRateLimitStore{Backend: &memoryCASBackend{values: make(map[string][]byte)}, Prefix: "ifttt:"},
+	} {
+		for i := 0; i < 2; i++ {
+			if ok, _, err := store.Take("a", limit); !ok || err != nil {
+				t.Fatalf("%s: expected request %d to be allowed: %v\n", name, i, err)
+			}
+		}
+		if ok, wait, err := store.Take("a", limit); ok || err != nil || wait <= 59*time.Minute {
+			t.Errorf("%s: expected request to be limited, got %v %v %v\n", name, ok, wait, err)
+			t.Fail()
+		}
+		if ok, _, _ := store.Take("b", limit); !ok {
+			t.Errorf("%s: expected buckets to be independent\n", name)
+			t.Fail()
+		}
+	}
+
+	backend := &memoryCASBackend{values: map[string][]byte{"a": []byte("garbage")}}
+	if _, _, err := (&SharedRateLimitStore{Backend: backend}).Take("a", limit); err == nil {
+		t.Errorf("Expected malformed bucket error\n")
+		t.Fail()
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	backend := &memoryCASBackend{values: make(map[string][]byte)}
+	limiter := &RateLimiter{
+		Store:   &SharedRateLimitStore{Backend: backend},
+		Default: RateLimit{Requests: 1, Per: time.Hour},
+		Slugs:   map[string]RateLimit{"test_trigger": {Requests: 2, Per: time.Hour}},
+	}
+	realtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer realtime.Close()
+	service := &Service{
+		ServiceKey:       "servicekey",
+		RealtimeURL:      realtime.URL,
+		PollInvalidators: []PollInvalidator{limiter},
+		Authenticator: AuthenticatorFunc(func(ctx context.Context, token string, req *Request) (*Principal, error) {
+			return &Principal{ID: token}, nil
+		}),
+	}
+	service.RegisterTrigger("test_trigger", testTrigger{})
+	service.RegisterAction("test_action", testLimitedAction{})
+	service.Use(limiter.Middleware())
+
+	doMethod := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("IFTTT-Service-Key", "servicekey")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		service.ServeHTTP(res, req)
+		return res
+	}
+	do := func(path string, token string, body string) *httptest.ResponseRecorder {
+		return doMethod("POST", path, token, body)
+	}
+
+	action := `{"actionFields":{},"user":{}}`
+	if res := do("/ifttt/v1/actions/test_action", "alice", action); res.Code != 200 {
+		t.Fatalf("Unexpected response: %d %s\n", res.Code, res.Body.String())
+	}
+	res := do("/ifttt/v1/actions/test_action", "alice", action)
+	if res.Code != 429 || res.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Expected limited action, got %d %v\n", res.Code, res.Header())
+		t.Fail()
+	}
+	if res := do("/ifttt/v1/actions/test_action", "bob", action); res.Code != 200 {
+		t.Errorf("Expected other users not to be limited, got %d\n", res.Code)
+		t.Fail()
+	}
+	if res := do("/ifttt/v1/actions/test_action", "", action); res.Code != 200 {
+		t.Errorf("Expected requests without a key not to be limited, got %d\n", res.Code)
+		t.Fail()
+	}
+
+	pollAs := func(token string, identity string) *httptest.ResponseRecorder {
+		return do("/ifttt/v1/triggers/test_trigger", token, `{"trigger_identity":"`+identity+`","triggerFields":{"foo":"bar"},"user":{}}`)
+	}
+	poll := func(identity string) *httptest.ResponseRecorder {
+		return pollAs("alice", identity)
+	}
+	empty := func(res *httptest.ResponseRecorder) bool {
+		return res.Code == 200 && jsonEqual(res.Body.Bytes(), []byte(`{"data":[]}`))
+	}
+	first := poll("a")
+	if first.Code != 200 || poll("b").Code != 200 {
+		t.Fatalf("Unexpected poll response: %d\n", first.Code)
+	}
+	if res := poll("a"); res.Code != 200 || res.Body.String() != first.Body.String() || res.Header().Get("X-IFTTT-Realtime") != "1" {
+		t.Errorf("Expected the last response of the identity, got %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+	if res := poll("c"); !empty(res) {
+		t.Errorf("Expected an empty poll response, got %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+
+	// last responses are dropped when their trigger identity or user is notified, or the identity is deleted
+	evt := Notification{}
+	evt.AddTrigger("a")
+	if err := service.Notify(evt); err != nil {
+		t.Fatalf("Unexpected notify error: %v\n", err)
+	}
+	if res := poll("a"); !empty(res) {
+		t.Errorf("Expected the response of a notified identity to be dropped, got %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+	pollAs("bob", "d")
+	pollAs("bob", "e")
+	evt = Notification{}
+	evt.AddUser("bob")
+	service.Notify(evt)
+	if res := pollAs("bob", "d"); !empty(res) {
+		t.Errorf("Expected the response of a notified user to be dropped, got %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+	pollAs("carol", "f")
+	pollAs("carol", "g")
+	if res := doMethod("DELETE", "/ifttt/v1/triggers/test_trigger/trigger_identity/f", "", ""); res.Code != 200 {
+		t.Fatalf("Unexpected delete response: %d %s\n", res.Code, res.Body.String())
+	}
+	if res := pollAs("carol", "f"); !empty(res) {
+		t.Errorf("Expected the response of a deleted identity to be dropped, got %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+	// even if the deletion is limited
+	pollAs("dave", "h")
+	pollAs("dave", "i")
+	doMethod("DELETE", "/ifttt/v1/triggers/test_trigger/trigger_identity/h", "erin", "")
+	doMethod("DELETE", "/ifttt/v1/triggers/test_trigger/trigger_identity/g", "erin", "")
+	if res := doMethod("DELETE", "/ifttt/v1/triggers/test_trigger/trigger_identity/i", "erin", ""); res.Code != 429 {
+		t.Fatalf("Expected limited delete, got %d %s\n", res.Code, res.Body.String())
+	}
+	if res := pollAs("dave", "i"); !empty(res) {
+		t.Errorf("Expected the response of an identity deleted while limited to be dropped, got %d %s\n", res.Code, res.Body.String())
+		t.Fail()
+	}
+
+	// the limiter fails open
+	backend.fail = true
+	if res := do("/ifttt/v1/actions/test_action", "alice", action); res.Code != 200 {
+		t.Errorf("Expected requests to be allowed when the store fails, got %d\n", res.Code)
+		t.Fail()
+	}
+}
+
+func TestRateLimiterPollTTL(t *testing.T) {
+	limiter := &RateLimiter{PollTTL: 10 * time.Millisecond}
+	req := &Request{Type: TriggerFetch, Slug: "test_trigger", TriggerIdentity: "a"}
+	limiter.recordPoll(req, &Response{Status: 200, Body: []byte(`{"data":[{"meta":{"id":"1"}}]}`)})
+	if res := limiter.limitedPoll(req); !jsonEqual(res.Body, []byte(`{"data":[{"meta":{"id":"1"}}]}`)) {
+		t.Fatalf("Expected the last response, got %s\n", res.Body)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if res := limiter.limitedPoll(req); !jsonEqual(res.Body, []byte(`{"data":[]}`)) {
+		t.Fatalf("Expected the last response to expire, got %s\n", res.Body)
+	}
+	limiter.lastSweep = time.Time{}
+	limiter.limitedPoll(req)
+	if len(limiter.polls) != 0 {
+		t.Fatalf("Expected expired responses to be swept, got %v\n", limiter.polls)
+	}
+}
+
+func TestRateLimiterLastPoll(t *testing.T) {
+	limiter := &RateLimiter{}
+	body := func(data string) *gabs.Container {
+		res, _ := gabs.ParseJSON([]byte(data))
+		return res
+	}
+	req := &Request{Type: TriggerFetch, Slug: "test_trigger", TriggerIdentity: "a", DecodedBody: body(`{"trigger_identity":"a"}`)}
+	res := &Response{Status: 200, Header: http.Header{"X-Foo": {"bar"}}, Body: []byte(`{"data":[{"meta":{"id":"1"}},{"meta":{"id":"2"}}]}`)}
+	limiter.recordPoll(req, res)
+	// outer middlewares may modify the response after it is recorded
+	res.Header.Set("X-Foo", "baz")
+	if replayed := limiter.limitedPoll(req); replayed.Header.Get("X-Foo") != "bar" {
+		t.Fatalf("Expected the recorded response to be unaffected, got %v\n", replayed.Header)
+	}
+
+	limited := &Request{Type: TriggerFetch, Slug: "test_trigger", TriggerIdentity: "a", DecodedBody: body(`{"trigger_identity":"a","limit":1}`)}
+	if replayed := limiter.limitedPoll(limited); !jsonEqual(replayed.Body, []byte(`{"data":[]}`)) {
+		t.Fatalf("Expected polls with another limit not to get the response, got %s\n", replayed.Body)
+	}
+}
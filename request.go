@@ -17,6 +17,10 @@ var (
 	urlRegexpTriggerContextualValidation  = regexp.MustCompile("^/ifttt/v1/triggers/([^/]+)/validate$")
 	urlRegexpAction                       = regexp.MustCompile("^/ifttt/v1/actions/([^/]+)$")
 	urlRegexpActionFieldOption            = regexp.MustCompile("^/ifttt/v1/actions/([^/]+)/fields/([^/]+)/options$")
+	urlRegexpQuery                        = regexp.MustCompile("^/ifttt/v1/queries/([^/]+)$")
+	urlRegexpQueryFieldOption             = regexp.MustCompile("^/ifttt/v1/queries/([^/]+)/fields/([^/]+)/options$")
+	urlRegexpQueryFieldOptionValidation   = regexp.MustCompile("^/ifttt/v1/queries/([^/]+)/fields/([^/]+)/validate$")
+	urlRegexpQueryContextualValidation    = regexp.MustCompile("^/ifttt/v1/queries/([^/]+)/validate$")
 )
 
 // RequestType enumerates IFTTT request types
@@ -52,17 +56,66 @@ const (
 	// UserInfoRequest requests for the information of a user which will be displayed to the user on his service page
 	// https://platform.ifttt.com/docs/api_reference#user-information
 	UserInfoRequest
+	// TestSetupRequest requests for the access token and sample field values used by the IFTTT endpoint tests
+	// https://platform.ifttt.com/docs/testing#the-testsetup-endpoint
+	TestSetupRequest
+	// QueryFetch performs a query
+	// https://platform.ifttt.com/docs/api_reference#queries
+	QueryFetch
+	// QueryDynamicOptions requests dynamic field options from a query
+	// https://platform.ifttt.com/docs/api_reference#query-field-dynamic-options
+	QueryDynamicOptions
+	// QueryDynamicValidation requests the validation of a single field of a query
+	// https://platform.ifttt.com/docs/api_reference#query-field-dynamic-validation
+	QueryDynamicValidation
+	// QueryContextualValidation requests the validation of a combination of the query fields, you need to contact IFTTT to have this enabled
+	// https://platform.ifttt.com/docs/api_reference#query-field-contextual-validation
+	QueryContextualValidation
 )
 
+// requestTypeNames are the names of request types used in logs and metrics
+var requestTypeNames = map[RequestType]string{
+	Unknown:                     "unknown",
+	TriggerFetch:                "trigger_fetch",
+	TriggerDeleteNotify:         "trigger_delete_notify",
+	TriggerDynamicOptions:       "trigger_dynamic_options",
+	TriggerDynamicValidation:    "trigger_dynamic_validation",
+	TriggerContextualValidation: "trigger_contextual_validation",
+	ActionTrigger:               "action_trigger",
+	ActionDynamicOptions:        "action_dynamic_options",
+	ServiceStatus:               "service_status",
+	UserInfoRequest:             "user_info",
+	TestSetupRequest:            "test_setup",
+	QueryFetch:                  "query_fetch",
+	QueryDynamicOptions:         "query_dynamic_options",
+	QueryDynamicValidation:      "query_dynamic_validation",
+	QueryContextualValidation:   "query_contextual_validation",
+}
+
+// String implements fmt.Stringer
+func (c RequestType) String() string {
+	if name, ok := requestTypeNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
 // Request represents a parsed request from IFTTT
 type Request struct {
 	// Authenticated whether the request was carrying an OAuth token or not
 	Authenticated bool
-	// UserAccessToken if Authenticated is true, this is the token provided by IFTTT, is not, this is set to the claimed Service Key from IFTTT
+	// UserAccessToken the bearer token of the user provided by IFTTT, empty string if Authenticated is false
 	UserAccessToken string
+	// ServiceKey the service key claimed by IFTTT in the IFTTT-Service-Key header, which is verified on every request
+	ServiceKey string
+	// Principal the user resolved from UserAccessToken by Service.Authenticator, nil if no Authenticator is set or the request is not authenticated
+	Principal *Principal
+	// UserID the id of Principal, empty string if Principal is nil
+	UserID string
 	// RequestUUID is a unique string which identifies requests for debugging purposes
+	// It is read from the X-Request-ID header, or generated if IFTTT did not send one, and echoed in the response.
 	RequestUUID string
-	// Slug the slug name of the action/trigger, you generally dont need to check this as requests are automatically routed by the package to their endpoints.
+	// Slug the slug name of the action/trigger/query, you generally dont need to check this as requests are automatically routed by the package to their endpoints.
 	Slug string
 	// FieldSlug the field slug requested by the request, empty string in non field-related requests
 	FieldSlug string
@@ -76,6 +129,63 @@ type Request struct {
 	RawRequest *http.Request
 	// ServiceRef reference to the service struct
 	ServiceRef *Service
+	// Logger logs messages with the fields of this request (eg: request ID, slug), values of sensitive fields are redacted
+	Logger Logger
+}
+
+// Source describes the applet which initiated a trigger poll, action or query
+type Source struct {
+	// ID the id of the applet
+	ID string
+	// URL the URL of the applet on IFTTT
+	URL string
+}
+
+// parseSource reads the ifttt_source object of body
+func parseSource(body *gabs.Container) Source {
+	res := Source{}
+	if body == nil {
+		return res
+	}
+	res.ID, _ = body.Path("ifttt_source.id").Data().(string)
+	res.URL, _ = body.Path("ifttt_source.url").Data().(string)
+	return res
+}
+
+// parseUser reads the user object of body
+// A missing user object is treated as empty.
+func parseUser(body *gabs.Container) (map[string]string, error) {
+	if body.S("user").Data() == nil {
+		return make(map[string]string), nil
+	}
+	fields, err := body.S("user").ChildrenMap()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	for key, val := range fields {
+		res[key] = fieldString(val.Data())
+	}
+	return res, nil
+}
+
+// parseMetadata returns the top level keys of body which were not exposed elsewhere
+func parseMetadata(body *gabs.Container, known ...string) map[string]interface{} {
+	res := make(map[string]interface{})
+	if body == nil {
+		return res
+	}
+	obj, ok := body.Data().(map[string]interface{})
+	if !ok {
+		return res
+	}
+	for key, val := range obj {
+		res[key] = val
+	}
+	for _, key := range append(known, "ifttt_source", "user") {
+		delete(res, key)
+	}
+	return res
 }
 
 func parseRequest(r *http.Request) (*Request, error) {
@@ -85,13 +195,10 @@ func parseRequest(r *http.Request) (*Request, error) {
 		RawRequest:  r,
 	}
 
-	authheader := r.Header.Get("Authorization")
-	if authheader != "" {
+	res.ServiceKey = r.Header.Get("IFTTT-Service-Key")
+	if authheader := r.Header.Get("Authorization"); authheader != "" {
 		res.Authenticated = true
 		res.UserAccessToken = strings.TrimPrefix(authheader, "Bearer ")
-	} else {
-		res.Authenticated = false
-		res.UserAccessToken = r.Header.Get("IFTTT-Service-Key")
 	}
 
 	defer r.Body.Close()
@@ -100,7 +207,7 @@ func parseRequest(r *http.Request) (*Request, error) {
 		return nil, err
 	} else if len(data) > 0 {
 		if decodeData, err := gabs.ParseJSON(data); err != nil {
-			return nil, err
+			return nil, requestError{http.StatusBadRequest, "Malformed JSON body: " + err.Error()}
 		} else {
 			res.DecodedBody = decodeData
 		}
@@ -117,6 +224,11 @@ func parseRequest(r *http.Request) (*Request, error) {
 		case "GET":
 			res.Type = ServiceStatus
 		}
+	case strings.HasPrefix(r.RequestURI, "/ifttt/v1/test/setup"):
+		switch r.Method {
+		case "POST":
+			res.Type = TestSetupRequest
+		}
 	case strings.HasPrefix(r.RequestURI, "/ifttt/v1/triggers"):
 		switch {
 		case urlRegexpTrigger.MatchString(r.RequestURI):
@@ -124,7 +236,9 @@ func parseRequest(r *http.Request) (*Request, error) {
 			case "POST":
 				match := urlRegexpTrigger.FindStringSubmatch(r.RequestURI)
 				res.Slug = match[1]
-				res.TriggerIdentity = res.DecodedBody.Path("trigger_identity").Data().(string)
+				if res.DecodedBody != nil {
+					res.TriggerIdentity, _ = res.DecodedBody.Path("trigger_identity").Data().(string)
+				}
 				res.Type = TriggerFetch
 			}
 		case urlRegexpTriggerIdentity.MatchString(r.RequestURI):
@@ -177,6 +291,140 @@ func parseRequest(r *http.Request) (*Request, error) {
 				res.Type = ActionDynamicOptions
 			}
 		}
+	case strings.HasPrefix(r.RequestURI, "/ifttt/v1/queries"):
+		switch {
+		case urlRegexpQuery.MatchString(r.RequestURI):
+			switch r.Method {
+			case "POST":
+				match := urlRegexpQuery.FindStringSubmatch(r.RequestURI)
+				res.Slug = match[1]
+				res.Type = QueryFetch
+			}
+		case urlRegexpQueryFieldOption.MatchString(r.RequestURI):
+			switch r.Method {
+			case "POST":
+				match := urlRegexpQueryFieldOption.FindStringSubmatch(r.RequestURI)
+				res.Slug = match[1]
+				res.FieldSlug = match[2]
+				res.Type = QueryDynamicOptions
+			}
+		case urlRegexpQueryFieldOptionValidation.MatchString(r.RequestURI):
+			switch r.Method {
+			case "POST":
+				match := urlRegexpQueryFieldOptionValidation.FindStringSubmatch(r.RequestURI)
+				res.Slug = match[1]
+				res.FieldSlug = match[2]
+				res.Type = QueryDynamicValidation
+			}
+		case urlRegexpQueryContextualValidation.MatchString(r.RequestURI):
+			switch r.Method {
+			case "POST":
+				match := urlRegexpQueryContextualValidation.FindStringSubmatch(r.RequestURI)
+				res.Slug = match[1]
+				res.Type = QueryContextualValidation
+			}
+		}
 	}
 	return res, nil
 }
+
+// bodyKind enumerates the JSON types of keys checked by validateRequest
+type bodyKind int
+
+const (
+	bodyAny bodyKind = iota
+	bodyString
+	bodyNumber
+	bodyObject
+)
+
+func (c bodyKind) String() string {
+	switch c {
+	case bodyString:
+		return "a string"
+	case bodyNumber:
+		return "a number"
+	case bodyObject:
+		return "an object"
+	}
+	return "a value"
+}
+
+// bodyKey describes a top level key of a request body
+type bodyKey struct {
+	name     string
+	kind     bodyKind
+	required bool
+	// nullable whether an optional key may be null
+	nullable bool
+}
+
+var (
+	sourceKeys = []bodyKey{
+		{"user", bodyObject, false, true},
+		{"ifttt_source", bodyObject, false, true},
+	}
+	// bodyKeys are the top level keys checked for each request type
+	bodyKeys = map[RequestType][]bodyKey{
+		TriggerFetch: append([]bodyKey{
+			{"trigger_identity", bodyString, true, false},
+			{"triggerFields", bodyObject, true, false},
+			{"limit", bodyNumber, false, false},
+		}, sourceKeys...),
+		ActionTrigger: append([]bodyKey{
+			{"actionFields", bodyObject, true, false},
+		}, sourceKeys...),
+		QueryFetch: append([]bodyKey{
+			{"queryFields", bodyObject, true, false},
+			{"limit", bodyNumber, false, false},
+			{"cursor", bodyString, false, false},
+		}, sourceKeys...),
+		TriggerDynamicValidation:    {{"value", bodyAny, true, false}},
+		QueryDynamicValidation:      {{"value", bodyAny, true, false}},
+		TriggerContextualValidation: {{"values", bodyObject, true, false}},
+		QueryContextualValidation:   {{"values", bodyObject, true, false}},
+	}
+)
+
+// validateRequest checks that req was routed and its body contains the keys required by its type, returning a requestError if not
+func validateRequest(req *Request) error {
+	if req.Type == Unknown {
+		return requestError{http.StatusNotFound, "Not Found"}
+	}
+	keys := bodyKeys[req.Type]
+	if len(keys) == 0 {
+		return nil
+	}
+	var obj map[string]interface{}
+	if req.DecodedBody != nil {
+		var ok bool
+		if obj, ok = req.DecodedBody.Data().(map[string]interface{}); !ok {
+			return requestError{http.StatusBadRequest, "Request body must be a JSON object"}
+		}
+	}
+	for _, key := range keys {
+		val, ok := obj[key.name]
+		if !ok || val == nil {
+			if key.required {
+				return requestError{http.StatusBadRequest, "Missing required key " + key.name}
+			}
+			if ok && !key.nullable {
+				return requestError{http.StatusBadRequest, "Key " + key.name + " must be " + key.kind.String()}
+			}
+			continue
+		}
+		valid := true
+		switch key.kind {
+		case bodyString:
+			_, valid = val.(string)
+		case bodyNumber:
+			_, valid = val.(float64)
+		case bodyObject:
+			_, valid = val.(map[string]interface{})
+		}
+		if !valid {
+			return requestError{http.StatusBadRequest, "Key " + key.name + " must be " + key.kind.String()}
+		}
+	}
+	return nil
+}
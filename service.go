@@ -2,32 +2,92 @@ package ifttt
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Jeffail/gabs"
-	uuid "github.com/satori/go.uuid"
 )
 
+// DefaultRealtimeURL is the base URL of the IFTTT realtime API
+const DefaultRealtimeURL = "https://realtime.ifttt.com"
+
 // Service described the IFTTT service and handles requests from IFTTT
 type Service struct {
-	triggers map[string]Trigger
-	actions  map[string]Action
+	triggers map[string]TriggerWithContext
+	actions  map[string]ActionWithContext
+	queries  map[string]QueryWithContext
+	// middlewares wrap the dispatch of requests, see Use
+	middlewares []Middleware
 	// IFTTT service key used to identify your service
 	// get it from you dashboard
 	ServiceKey string
+	// ServiceKeys is the set of additional accepted service keys, use it to rotate keys without downtime
+	// If set, ServiceKey is only accepted if it is not empty
+	ServiceKeys *ServiceKeySet
 	// Healthy should return whether the service is functioning normally
 	// Defaults to true
 	Healthy func() bool
 	// UserInfo should return user info identified by req.UserAccessToken
 	// if your service does not require authentication, passing nil should be OK
 	UserInfo func(req *Request) (*UserInfo, error)
-	logger   *log.Logger
+	// TestSetup should return the access token and sample values used by the IFTTT endpoint tests
+	// If nil, the samples are collected from registered triggers and actions which implement TriggerSampler or ActionSampler
+	TestSetup func(req *Request) (*TestSetupResult, error)
+	// TestAccessToken is the access token returned to the test setup request when TestSetup is nil
+	TestAccessToken string
+	// Timeouts sets the deadline of the context passed to handlers for each request type
+	// The context is derived from the inbound request and canceled when IFTTT disconnects, request types absent from this map have no other deadline.
+	Timeouts map[RequestType]time.Duration
+	// Identities is the registry of active trigger identities
+	// If set, identities are recorded on each trigger poll and removed when IFTTT notifies the trigger has been deleted
+	Identities IdentityStore
+	// IdentityUserID should return the id of the user owning the trigger polled in req, which is recorded in the identity registry
+	// If nil, identities are recorded without a user id
+	IdentityUserID func(req *Request) (string, error)
+	// Events keeps the event history of triggers which implement StoredTrigger
+	// If set, polls to these triggers are answered from the store instead of calling Poll
+	Events EventStore
+	// RealtimeURL is the base URL of the IFTTT realtime API used by Notify, defaults to DefaultRealtimeURL
+	RealtimeURL string
+	// HTTPClient is the client used by Notify, defaults to http.DefaultClient
+	// Set its Transport to route notifications through a custom http.RoundTripper
+	HTTPClient *http.Client
+	// OAuth2 is the optional OAuth2 authorization server of the service
+	// If set, its endpoints are served under /oauth2/ and it is used as the Authenticator if Authenticator is nil
+	OAuth2 *OAuth2Provider
+	// Authenticator resolves the access tokens of authenticated requests into Request.Principal before handlers are called
+	// Requests with an invalid token, and requests without a token to handlers which require authentication (see AuthRequirer), are refused with 401.
+	// Wrap it with NewCachingAuthenticator to cache the results.
+	Authenticator Authenticator
+	// DefaultTimezone is the timezone of users whose timezone is absent or unknown, defaults to UTC
+	DefaultTimezone *time.Location
+	// Catalog provides the messages of LocalizedError returned by handlers, rendered in the locale of the user
+	// The locale is read from the locale key of the user metadata or the Accept-Language header, falling back to DefaultLocale.
+	// If nil, or if the message is not available, the error is returned as is.
+	Catalog Catalog
+	// Logger receives the log messages of the service, nil to disable logging
+	// Each request is logged with its request ID, type, slug, status and latency, tokens and service keys are redacted.
+	Logger Logger
+	// RedactFields are additional log field names whose values are redacted
+	RedactFields []string
+	// Metrics collects the metrics of requests and realtime notifications if set, serve it on a separate path to expose them
+	Metrics *Metrics
+	// PollCache caches the events of triggers which implement CachedTrigger if set
+	// Cached events are dropped when their trigger identity or its user is notified through Notify, or when IFTTT deletes the trigger identity.
+	PollCache *PollCache
+	// PollInvalidators are invalidated along with PollCache, eg: a RateLimiter
+	PollInvalidators []PollInvalidator
+	// Tracer starts spans around parsing, authentication, handler dispatch and marshaling of each request, and around Notify
+	// Spans of requests carry the request ID, type, slug and trigger identity, nil to disable tracing.
+	Tracer Tracer
 }
 
 func prepareHeader(w http.ResponseWriter) {
@@ -37,300 +97,687 @@ func prepareHeader(w http.ResponseWriter) {
 
 // RegisterTrigger registers a trigger handler which implements Trigger
 func (c *Service) RegisterTrigger(slug string, handler Trigger) {
+	c.RegisterTriggerWithContext(slug, triggerAdapter{handler})
+}
+
+// RegisterTriggerWithContext registers a trigger handler which implements TriggerWithContext
+func (c *Service) RegisterTriggerWithContext(slug string, handler TriggerWithContext) {
 	if c.triggers == nil {
-		c.triggers = make(map[string]Trigger)
+		c.triggers = make(map[string]TriggerWithContext)
 	}
 	c.triggers[slug] = handler
 }
 
 // RegisterAction registers an action handler which implements Action
 func (c *Service) RegisterAction(slug string, handler Action) {
+	c.RegisterActionWithContext(slug, actionAdapter{handler})
+}
+
+// RegisterActionWithContext registers an action handler which implements ActionWithContext
+func (c *Service) RegisterActionWithContext(slug string, handler ActionWithContext) {
 	if c.actions == nil {
-		c.actions = make(map[string]Action)
+		c.actions = make(map[string]ActionWithContext)
 	}
 	c.actions[slug] = handler
 }
 
+// RegisterQuery registers a query handler which implements Query
+func (c *Service) RegisterQuery(slug string, handler Query) {
+	c.RegisterQueryWithContext(slug, queryAdapter{handler})
+}
+
+// RegisterQueryWithContext registers a query handler which implements QueryWithContext
+func (c *Service) RegisterQueryWithContext(slug string, handler QueryWithContext) {
+	if c.queries == nil {
+		c.queries = make(map[string]QueryWithContext)
+	}
+	c.queries[slug] = handler
+}
+
+// unwrapHandler returns the handler originally registered, for detecting optional interfaces
+func unwrapHandler(handler interface{}) interface{} {
+	switch h := handler.(type) {
+	case triggerAdapter:
+		return h.Trigger
+	case actionAdapter:
+		return h.Action
+	case queryAdapter:
+		return h.Query
+	}
+	return handler
+}
+
+// handlerContext derives the context passed to handlers from the context of the inbound request
+func (c *Service) handlerContext(ctx context.Context, typ RequestType) (context.Context, context.CancelFunc) {
+	if timeout, ok := c.Timeouts[typ]; ok && timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
 // EnableDebug enabled debug output of this service
+//
+// Deprecated: set Logger instead, this is equivalent to setting it to NewStdLogger(os.Stdout, LevelDebug).
 func (c *Service) EnableDebug() {
-	c.logger = log.New(os.Stdout, "IFTTT: ", log.LstdFlags)
+	c.Logger = NewStdLogger(os.Stdout, LevelDebug)
 }
 
 // ServeHTTP implements http.Handler and handles http requests
 func (c Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	logger := newFieldLogger(c.Logger, c.RedactFields).with("method", r.Method, "path", r.URL.Path, "request_id", requestID)
+	var failure error
+	var req *Request
+	panicked := false
+	routed := false
+
+	rootCtx, root := c.startSpan(r.Context(), SpanRequest)
+	root.SetAttributes("http.method", r.Method, "http.target", r.URL.Path, "request_id", requestID)
+	var stage Span = noopSpan{}
+	// stages are children of the root span, started from parent once it carries the deadline of the handler
+	parent := rootCtx
+	// enter ends the current stage span with err and starts the next stage, returning the context carrying it
+	enter := func(name string, err error) context.Context {
+		stage.End(err)
+		var stageCtx context.Context
+		stageCtx, stage = c.startSpan(parent, name)
+		return stageCtx
+	}
+	defer func() {
+		latency := time.Since(start)
+		if panicked {
+			stage.End(failure)
+		} else {
+			stage.End(nil)
+		}
+		root.SetAttributes("http.status_code", sw.status)
+		root.End(failure)
+		if c.Metrics != nil {
+			typ, slug := Unknown, ""
+			if req != nil {
+				typ = req.Type
+				// slugs are only used once routed to a registered handler, to bound the number of series
+				if routed {
+					slug = req.Slug
+				}
+			}
+			c.Metrics.observeRequest(typ, slug, sw.status, latency, panicked)
+		}
+		args := []interface{}{"status", sw.status, "latency", latency}
+		if failure != nil {
+			args = append(args, "error", failure.Error())
+		}
+		if sw.status >= 500 {
+			logger.Error("Request failed", args...)
+		} else {
+			logger.Info("Request handled", args...)
+		}
+	}()
 	defer func() {
 		if err := recover(); err != nil {
 			w.WriteHeader(500)
 			w.Write(marshalError(ErrorPanicDuringProcess, false))
-			if c.logger != nil {
-				c.logger.Printf("Panic during processing! Stach Trace: %s\n", debug.Stack())
-			}
+			failure = ErrorPanicDuringProcess
+			panicked = true
+			logger.Error("Panic during processing", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
 		}
 	}()
 
 	if c.actions == nil {
-		c.actions = make(map[string]Action)
+		c.actions = make(map[string]ActionWithContext)
 	}
 	if c.triggers == nil {
-		c.triggers = make(map[string]Trigger)
+		c.triggers = make(map[string]TriggerWithContext)
+	}
+	if c.queries == nil {
+		c.queries = make(map[string]QueryWithContext)
 	}
 
+	writeFailure := func(err error, action bool, skip bool) {
+		failure = err
+		enter(SpanMarshal, err)
+		writeError(w, c.localize(req, err), action, skip)
+	}
 	handleError := func(err error) {
-		if _, ok := err.(AuthError); ok {
-			w.WriteHeader(401)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		w.Write(marshalError(err, false))
+		writeFailure(err, false, false)
+	}
+
+	if c.OAuth2 != nil && isOAuth2Path(r.URL.Path) {
+		c.OAuth2.ServeHTTP(w, r)
+		return
 	}
 
 	prepareHeader(w)
 
+	enter(SpanParse, nil)
 	req, err := parseRequest(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Error"))
+		logger.Warn("Request refused due to parse error", "error", err.Error())
+		handleError(err)
 		return
 	}
 	req.ServiceRef = &c
+	req.RequestUUID = requestID
+	logger = logger.with("type", req.Type.String(), "slug", req.Slug)
+	root.SetAttributes("ifttt.type", req.Type.String(), "ifttt.slug", req.Slug)
+	if req.TriggerIdentity != "" {
+		logger = logger.with("trigger_identity", req.TriggerIdentity)
+		root.SetAttributes("ifttt.trigger_identity", req.TriggerIdentity)
+	}
+	req.Logger = logger
+
+	ctx, cancel := c.handlerContext(rootCtx, req.Type)
+	defer cancel()
+	parent = ctx
+
+	logger.Debug("Got request", "authenticated", req.Authenticated)
+
+	authCtx := enter(SpanAuth, nil)
+
+	// IFTTT sends the service key with every request, refuse to handle requests with an incorrect one even if they carry a user token.
+	if !c.verifyServiceKey(req.ServiceKey) {
+		logger.Warn("Request refused due to incorrect service key", "service_key", req.ServiceKey)
+		handleError(AuthError{"Service Key does not present."})
+		return
+	}
+
+	if err := validateRequest(req); err != nil {
+		logger.Warn("Request refused due to invalid request", "error", err.Error())
+		handleError(err)
+		return
+	}
+
+	if err := c.authenticate(authCtx, req); err != nil {
+		logger.Warn("Request refused due to failed authentication", "error", err.Error())
+		handleError(err)
+		return
+	}
 
-	if c.logger != nil {
-		c.logger.Printf("Got request %s - %s with type %d\n", r.RequestURI, req.DecodedBody.String(), req.Type)
+	handleCtx := enter(SpanHandle, nil)
+	if err := c.route(req); err != nil {
+		handleError(err)
+		return
+	}
+	routed = true
+	// deletions invalidate polls before middlewares, which may refuse them
+	if req.Type == TriggerDeleteNotify {
+		c.invalidatePolls([]string{req.TriggerIdentity}, nil)
 	}
 
-	// If the request is unauthenticated and the service key is incorrect, refuse to handle it.
-	if !req.Authenticated && req.UserAccessToken != c.ServiceKey {
-		if c.logger != nil {
-			c.logger.Printf("Request %s refused due to incorrect claimed service key: %s", r.RequestURI, req.UserAccessToken)
+	res := c.chain()(handleCtx, req)
+	if res == nil {
+		res = &Response{Err: errors.New("Middleware returned no response")}
+	}
+	for key, values := range res.Header {
+		for _, val := range values {
+			w.Header().Add(key, val)
 		}
-		handleError(AuthError{"Service Key does not present."})
+	}
+	if res.Err != nil {
+		writeFailure(res.Err, req.Type == ActionTrigger, res.Skip)
 		return
 	}
+	enter(SpanMarshal, nil)
+	status := res.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := res.Body
+	if body == nil {
+		body = []byte{}
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// route returns NotFoundError if no handler is registered for the slug of req
+func (c *Service) route(req *Request) error {
+	switch req.Type {
+	case ActionTrigger, ActionDynamicOptions:
+		if _, ok := c.actions[req.Slug]; !ok {
+			return NotFoundError{"Action Not Registered"}
+		}
+	case TriggerFetch, TriggerDynamicOptions, TriggerDynamicValidation, TriggerContextualValidation, TriggerDeleteNotify:
+		if _, ok := c.triggers[req.Slug]; !ok {
+			return NotFoundError{"Trigger Not Registered"}
+		}
+	case QueryFetch, QueryDynamicOptions, QueryDynamicValidation, QueryContextualValidation:
+		if _, ok := c.queries[req.Slug]; !ok {
+			return NotFoundError{"Query Not Registered"}
+		}
+	}
+	return nil
+}
 
+// dispatch calls the handler of req and builds its response, it is the innermost HandlerFunc of the middleware chain
+func (c *Service) dispatch(ctx context.Context, req *Request) *Response {
+	logger := req.Logger
+	if logger == nil {
+		logger = newFieldLogger(nil, nil)
+	}
 	switch req.Type {
 	case ServiceStatus:
 		if c.Healthy == nil || c.Healthy() {
-			w.WriteHeader(200)
-			w.Write([]byte{})
-		} else {
-			w.WriteHeader(503)
-			w.Write([]byte{})
+			return &Response{Status: 200}
 		}
+		return &Response{Status: 503}
 	case UserInfoRequest:
+		if c.UserInfo == nil && c.OAuth2 != nil && c.OAuth2.Users != nil {
+			info, err := c.OAuth2.Users.UserInfo(req.UserID)
+			if err != nil {
+				return &Response{Err: err}
+			}
+			return &Response{Status: 200, Body: info.marshal()}
+		}
 		if c.UserInfo == nil {
-			handleError(errors.New("User info not available"))
-		} else if info, err := c.UserInfo(req); err != nil {
-			handleError(err)
-		} else {
-			w.WriteHeader(200)
-			w.Write(info.marshal())
+			return &Response{Err: errors.New("User info not available")}
+		}
+		info, err := c.UserInfo(req)
+		if err != nil {
+			return &Response{Err: err}
+		}
+		return &Response{Status: 200, Body: info.marshal()}
+	case TestSetupRequest:
+		setup := c.TestSetup
+		if setup == nil {
+			setup = c.testSetupFromSamples
+		}
+		res, err := setup(req)
+		if err != nil {
+			return &Response{Err: err}
 		}
+		return &Response{Status: 200, Body: res.marshal()}
 	case ActionTrigger:
 		action, ok := c.actions[req.Slug]
 		if !ok {
-			handleError(errors.New("Action Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Action Not Registered"}}
 		}
 		ahq := &ActionHandleRequest{
-			make(map[string]string),
-			make(map[string]string),
+			Source:   parseSource(req.DecodedBody),
+			Metadata: parseMetadata(req.DecodedBody, "actionFields"),
 		}
-		if fields, err := req.DecodedBody.S("actionFields").ChildrenMap(); err != nil {
-			handleError(err)
-			return
+		if user, err := parseUser(req.DecodedBody); err != nil {
+			return &Response{Err: err}
 		} else {
-			for key, val := range fields {
-				ahq.ActionFields[key] = val.Data().(string)
-			}
+			ahq.User, ahq.UserContext = user, c.userContext(user, logger)
 		}
-
-		if fields, err := req.DecodedBody.S("user").ChildrenMap(); err != nil {
-			handleError(err)
-			return
+		if strs, values, err := decodeFields(req.DecodedBody, "actionFields", action, ahq.UserContext.Zone); err != nil {
+			_, skip := err.(FieldErrors)
+			return &Response{Err: err, Skip: skip}
 		} else {
-			for key, val := range fields {
-				ahq.User[key] = val.Data().(string)
-			}
+			ahq.ActionFields, ahq.Fields = strs, values
 		}
-		if res, skip, err := action.Handle(ahq, req); err != nil {
-			if _, ok := err.(AuthError); ok {
-				handleError(err)
-			}
-			w.WriteHeader(400)
-			w.Write(marshalError(err, skip))
-			return
-		} else {
-			w.WriteHeader(200)
-			w.Write(res.marshal())
+
+		res, skip, err := action.HandleWithContext(ctx, ahq, req)
+		if err != nil {
+			return &Response{Err: err, Skip: skip}
 		}
+		return &Response{Status: 200, Body: res.marshal()}
 	case TriggerFetch:
 		trigger, ok := c.triggers[req.Slug]
 		if !ok {
-			handleError(errors.New("Trigger Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Trigger Not Registered"}}
 		}
 		tpr := &TriggerPollRequest{
-			"",
-			make(map[string]string),
-			50,
-			make(map[string]string),
+			Limit:    50,
+			Source:   parseSource(req.DecodedBody),
+			Metadata: parseMetadata(req.DecodedBody, "trigger_identity", "triggerFields", "limit"),
 		}
 
-		tpr.TriggerIdentity = req.DecodedBody.S("trigger_identity").Data().(string)
-		if fields, err := req.DecodedBody.S("triggerFields").ChildrenMap(); err != nil {
-			handleError(err)
-			return
+		tpr.TriggerIdentity, _ = req.DecodedBody.S("trigger_identity").Data().(string)
+		if user, err := parseUser(req.DecodedBody); err != nil {
+			return &Response{Err: err}
 		} else {
-			for key, val := range fields {
-				tpr.TriggerFields[key] = val.Data().(string)
-			}
+			tpr.User, tpr.UserContext = user, c.userContext(user, logger)
 		}
-
-		if fields, err := req.DecodedBody.S("user").ChildrenMap(); err != nil {
-			handleError(err)
-			return
+		if strs, values, err := decodeFields(req.DecodedBody, "triggerFields", trigger, tpr.UserContext.Zone); err != nil {
+			return &Response{Err: err}
 		} else {
-			for key, val := range fields {
-				tpr.User[key] = val.Data().(string)
-			}
+			tpr.TriggerFields, tpr.Fields = strs, values
 		}
-		if req.DecodedBody.Exists("limit") {
-			tpr.Limit = int(req.DecodedBody.S("limit").Data().(float64))
+
+		if limit, ok := req.DecodedBody.S("limit").Data().(float64); ok {
+			tpr.Limit = int(limit)
 		}
-		if evts, err := trigger.Poll(tpr, req); err != nil {
-			handleError(err)
-			return
-		} else {
-			if trigger.RealTime() {
-				w.Header().Add("X-IFTTT-Realtime", "1")
+		if c.Identities != nil {
+			if err := c.recordIdentity(tpr, req); err != nil {
+				logger.Warn("Failed to record trigger identity", "error", err.Error())
 			}
-			w.WriteHeader(200)
-			w.Write(evts.marshal())
 		}
+		evts, err := c.poll(ctx, trigger, tpr, req)
+		if err != nil {
+			return &Response{Err: err}
+		}
+		res := &Response{Status: 200, Header: make(http.Header)}
+		if trigger.RealTime() {
+			res.Header.Set("X-IFTTT-Realtime", "1")
+		}
+		evts = evts.limit(tpr.Limit)
+		if c.Metrics != nil {
+			c.Metrics.observePoll(req.Slug, len(evts))
+		}
+		res.Body = evts.marshal()
+		return res
 	case ActionDynamicOptions:
 		action, ok := c.actions[req.Slug]
 		if !ok {
-			handleError(errors.New("Action Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Action Not Registered"}}
 		}
-		if options, err := action.Options(req); err != nil {
-			handleError(err)
-			return
-		} else {
-			w.WriteHeader(200)
-			w.Write(options.marshal())
+		options, err := action.OptionsWithContext(ctx, req)
+		if err != nil {
+			return &Response{Err: err}
 		}
+		return &Response{Status: 200, Body: options.marshal()}
 	case TriggerDynamicOptions:
 		trigger, ok := c.triggers[req.Slug]
 		if !ok {
-			handleError(errors.New("Trigger Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Trigger Not Registered"}}
 		}
-
-		if options, err := trigger.Options(req); err != nil {
-			handleError(err)
-			return
-		} else {
-			w.WriteHeader(200)
-			w.Write(options.marshal())
+		options, err := trigger.OptionsWithContext(ctx, req)
+		if err != nil {
+			return &Response{Err: err}
 		}
+		return &Response{Status: 200, Body: options.marshal()}
 	case TriggerDynamicValidation:
 		trigger, ok := c.triggers[req.Slug]
 		if !ok {
-			handleError(errors.New("Trigger Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Trigger Not Registered"}}
 		}
 
-		if err := trigger.ValidateField(req.FieldSlug, req.DecodedBody.S("value").Data().(string), req); err != nil {
-			w.WriteHeader(200)
-			res := gabs.New()
-			res.Set(false, "data", "valid")
-			res.Set(err.Error(), "data", "message")
-			w.Write(res.Bytes())
-		} else {
-			w.WriteHeader(200)
-			res := gabs.New()
-			res.Set(true, "data", "valid")
-			w.Write(res.Bytes())
+		value := req.DecodedBody.S("value").Data()
+		err := validateFieldSchema(trigger, req.FieldSlug, value)
+		if err == nil {
+			err = trigger.ValidateFieldWithContext(ctx, req.FieldSlug, fieldString(value), req)
 		}
+		return &Response{Status: 200, Body: marshalFieldValidation(c.localize(req, err))}
 	case TriggerContextualValidation:
 		trigger, ok := c.triggers[req.Slug]
 		if !ok {
-			handleError(errors.New("Trigger Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Trigger Not Registered"}}
 		}
-		values := make(map[string]string)
-		keymap, err := req.DecodedBody.S("values").ChildrenMap()
+		values, err := contextValues(req)
 		if err != nil {
-			handleError(err)
-			return
+			return &Response{Err: err}
 		}
-		for key, val := range keymap {
-			values[key] = val.Data().(string)
+		ret, err := trigger.ValidateContextWithContext(ctx, values, req)
+		if err != nil {
+			return &Response{Err: err}
 		}
-
-		if ret, err := trigger.ValidateContext(values, req); err != nil {
-			handleError(err)
-			return
-		} else {
-			res := gabs.New()
-			res.Object("data")
-			for key, err := range ret {
-				if err == nil {
-					res.Set(true, "data", key, "valid")
-				} else {
-					res.Set(false, "data", key, "valid")
-					res.Set(err.Error(), "data", key, "message")
-				}
-			}
-			w.WriteHeader(200)
-			w.Write(res.Bytes())
+		for key, err := range ret {
+			ret[key] = c.localize(req, err)
 		}
+		return &Response{Status: 200, Body: marshalContextValidation(ret)}
 	case TriggerDeleteNotify:
 		trigger, ok := c.triggers[req.Slug]
 		if !ok {
-			handleError(errors.New("Trigger Not Registered"))
-			return
+			return &Response{Err: NotFoundError{"Trigger Not Registered"}}
+		}
+		if err := trigger.RemoveIdentityWithContext(ctx, req.TriggerIdentity); err != nil {
+			return &Response{Err: err}
+		}
+		if c.Identities != nil {
+			if err := c.Identities.Remove(req.TriggerIdentity); err != nil {
+				return &Response{Err: err}
+			}
+		}
+		if c.Events != nil {
+			if err := c.Events.Remove(IdentityEventKey(req.TriggerIdentity)); err != nil {
+				return &Response{Err: err}
+			}
+		}
+		return &Response{Status: 200}
+	case QueryFetch:
+		query, ok := c.queries[req.Slug]
+		if !ok {
+			return &Response{Err: NotFoundError{"Query Not Registered"}}
+		}
+		qr := &QueryRequest{
+			Limit:    50,
+			Source:   parseSource(req.DecodedBody),
+			Metadata: parseMetadata(req.DecodedBody, "queryFields", "limit", "cursor"),
+		}
+
+		if user, err := parseUser(req.DecodedBody); err != nil {
+			return &Response{Err: err}
+		} else {
+			qr.User, qr.UserContext = user, c.userContext(user, logger)
+		}
+		if strs, values, err := decodeFields(req.DecodedBody, "queryFields", query, qr.UserContext.Zone); err != nil {
+			return &Response{Err: err}
+		} else {
+			qr.QueryFields, qr.Fields = strs, values
+		}
+
+		if limit, ok := req.DecodedBody.S("limit").Data().(float64); ok {
+			qr.Limit = int(limit)
+		}
+		if cursor, ok := req.DecodedBody.S("cursor").Data().(string); ok {
+			qr.Cursor = cursor
+		}
+		res, err := query.QueryWithContext(ctx, qr, req)
+		if err != nil {
+			return &Response{Err: err}
+		}
+		return &Response{Status: 200, Body: res.limit(qr.Limit).marshal()}
+	case QueryDynamicOptions:
+		query, ok := c.queries[req.Slug]
+		if !ok {
+			return &Response{Err: NotFoundError{"Query Not Registered"}}
+		}
+		options, err := query.OptionsWithContext(ctx, req)
+		if err != nil {
+			return &Response{Err: err}
+		}
+		return &Response{Status: 200, Body: options.marshal()}
+	case QueryDynamicValidation:
+		query, ok := c.queries[req.Slug]
+		if !ok {
+			return &Response{Err: NotFoundError{"Query Not Registered"}}
+		}
+
+		value := req.DecodedBody.S("value").Data()
+		err := validateFieldSchema(query, req.FieldSlug, value)
+		if err == nil {
+			err = query.ValidateFieldWithContext(ctx, req.FieldSlug, fieldString(value), req)
+		}
+		return &Response{Status: 200, Body: marshalFieldValidation(c.localize(req, err))}
+	case QueryContextualValidation:
+		query, ok := c.queries[req.Slug]
+		if !ok {
+			return &Response{Err: NotFoundError{"Query Not Registered"}}
+		}
+		values, err := contextValues(req)
+		if err != nil {
+			return &Response{Err: err}
+		}
+		ret, err := query.ValidateContextWithContext(ctx, values, req)
+		if err != nil {
+			return &Response{Err: err}
+		}
+		for key, err := range ret {
+			ret[key] = c.localize(req, err)
+		}
+		return &Response{Status: 200, Body: marshalContextValidation(ret)}
+	}
+	return &Response{Err: requestError{http.StatusNotFound, "Not Found"}}
+}
+
+// contextValues returns the field values of a contextual validation request
+func contextValues(req *Request) (map[string]string, error) {
+	values := make(map[string]string)
+	keymap, err := req.DecodedBody.S("values").ChildrenMap()
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range keymap {
+		values[key] = fieldString(val.Data())
+	}
+	return values, nil
+}
+
+func marshalFieldValidation(err error) []byte {
+	res := gabs.New()
+	if err != nil {
+		res.Set(false, "data", "valid")
+		res.Set(err.Error(), "data", "message")
+	} else {
+		res.Set(true, "data", "valid")
+	}
+	return res.Bytes()
+}
+
+func marshalContextValidation(ret map[string]error) []byte {
+	res := gabs.New()
+	res.Object("data")
+	for key, err := range ret {
+		if err == nil {
+			res.Set(true, "data", key, "valid")
+		} else {
+			res.Set(false, "data", key, "valid")
+			res.Set(err.Error(), "data", key, "message")
 		}
-		if err := trigger.RemoveIdentity(req.TriggerIdentity); err != nil {
-			handleError(err)
-			return
+	}
+	return res.Bytes()
+}
+
+// poll returns the events of the polled trigger, from Service.Events if the trigger implements StoredTrigger
+func (c *Service) poll(ctx context.Context, trigger TriggerWithContext, tpr *TriggerPollRequest, req *Request) (TriggerEventCollection, error) {
+	if stored, ok := unwrapHandler(trigger).(StoredTrigger); ok && c.Events != nil {
+		key, err := stored.EventKey(tpr, req)
+		if err != nil {
+			return nil, err
 		}
-		w.WriteHeader(200)
-		w.Write([]byte{})
+		return c.Events.Events(key, tpr.Limit)
+	}
+	return c.cachedPoll(ctx, trigger, tpr, req)
+}
+
+// userContext builds the UserContext of the user metadata, logging timezones which cannot be resolved
+func (c *Service) userContext(user map[string]string, logger Logger) UserContext {
+	res := newUserContext(user, c.DefaultTimezone)
+	if !res.Resolved && res.Timezone != "" {
+		logger.Warn("Unknown user timezone", "timezone", res.Timezone, "fallback", res.Zone.String())
 	}
+	return res
+}
 
+// PushEvents adds events to Service.Events under key, use IdentityEventKey or UserEventKey to build the key
+func (c *Service) PushEvents(key string, events ...TriggerEvent) error {
+	if c.Events == nil {
+		return errors.New("Event store not configured")
+	}
+	return c.Events.Push(key, events...)
+}
+
+// recordIdentity records the trigger identity polled in tpr to the identity registry
+func (c *Service) recordIdentity(tpr *TriggerPollRequest, req *Request) error {
+	identity := TriggerIdentity{
+		Identity:      tpr.TriggerIdentity,
+		Slug:          req.Slug,
+		TriggerFields: tpr.TriggerFields,
+		User:          tpr.User,
+	}
+	if c.IdentityUserID != nil {
+		userid, err := c.IdentityUserID(req)
+		if err != nil {
+			return err
+		}
+		identity.UserID = userid
+	}
+	return c.Identities.Put(identity)
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// invalidatePolls drops the state kept about the polls of trigger identities and users from PollCache and PollInvalidators
+// It is called when trigger identities or users are notified through Notify, and when IFTTT deletes a trigger identity.
+func (c *Service) invalidatePolls(identities []string, userIDs []string) {
+	invalidators := c.PollInvalidators
+	if c.PollCache != nil {
+		invalidators = append([]PollInvalidator{c.PollCache}, invalidators...)
+	}
+	for _, invalidator := range invalidators {
+		invalidator.Invalidate(identities...)
+		invalidator.InvalidateUser(userIDs...)
+	}
 }
 
 // Notify implements the IFTTT realtime API and sends notifications to the IFTTT realtime notification endpoint
+// Notifications are limited to 100 entries per request, use a NotifyDispatcher to have them batched automatically.
+// The cached polls of the notified trigger identities and users are dropped from PollCache and PollInvalidators before IFTTT is notified.
+// Each notification is sent with a new X-Request-ID, which is added to the notify span.
 func (c *Service) Notify(evt Notification) error {
-	req, err := http.NewRequest("POST", "https://realtime.ifttt.com/v1/notifications", bytes.NewReader(evt.marshal()))
+	return c.NotifyWithContext(context.Background(), evt)
+}
+
+// NotifyWithContext is Notify, the request to IFTTT is canceled when ctx is done
+func (c *Service) NotifyWithContext(ctx context.Context, evt Notification) error {
+	c.invalidatePolls(evt.triggers, evt.users)
+	start := time.Now()
+	requestID := newRequestID()
+	ctx, span := c.startSpan(ctx, SpanNotify)
+	span.SetAttributes("request_id", requestID, "ifttt.entries", evt.len())
+	err := c.notify(ctx, evt, requestID)
+	span.End(err)
+	if c.Metrics != nil {
+		c.Metrics.observeNotification(evt.len(), err, time.Since(start))
+	}
+	return err
+}
+
+func (c *Service) notify(ctx context.Context, evt Notification, requestID string) error {
+	base := c.RealtimeURL
+	if base == "" {
+		base = DefaultRealtimeURL
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(base, "/")+"/v1/notifications", bytes.NewReader(evt.marshal()))
 	if err != nil {
-		return nil
+		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Accept-Charset", "utf-8")
 	req.Header.Set("Content-Type", "application/json")
-	uid, err := uuid.NewV1()
-	if err != nil {
-		return err
-	}
-	req.Header.Set("X-Request-ID", uid.String())
-	req.Header.Set("IFTTT-Service-Key", c.ServiceKey)
+	req.Header.Set("X-Request-ID", requestID)
+	req.Header.Set("IFTTT-Service-Key", c.outboundServiceKey())
 
-	client := new(http.Client)
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		response, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
 		if err != nil {
 			return err
 		}
-		return fmt.Errorf("Remote returned code %d with: %s", resp.StatusCode, string(response))
+		return newRealtimeError(resp, response)
 	}
 	return nil
 }
@@ -0,0 +1,223 @@
+package ifttt
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ServiceKey is an IFTTT service key accepted during a period of time
+type ServiceKey struct {
+	// Key the service key
+	Key string
+	// NotBefore the key is not accepted before this time, zero time for no lower bound
+	NotBefore time.Time
+	// NotAfter the key is not accepted after this time, zero time for no upper bound
+	NotAfter time.Time
+}
+
+// Active returns whether the key is accepted at now
+func (c ServiceKey) Active(now time.Time) bool {
+	if !c.NotBefore.IsZero() && now.Before(c.NotBefore) {
+		return false
+	}
+	if !c.NotAfter.IsZero() && now.After(c.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// ServiceKeyLoader returns the service keys read from some source
+type ServiceKeyLoader func() ([]ServiceKey, error)
+
+// ServiceKeySet is a set of accepted service keys which can be replaced at runtime.
+// Keep the old and the new key in the set while rotating the key on the IFTTT dashboard to avoid downtime.
+type ServiceKeySet struct {
+	lock sync.RWMutex
+	keys []ServiceKey
+}
+
+// NewServiceKeySet creates a ServiceKeySet with the keys
+func NewServiceKeySet(keys ...ServiceKey) *ServiceKeySet {
+	res := &ServiceKeySet{}
+	res.Set(keys...)
+	return res
+}
+
+// Set replaces the keys of the set
+func (c *ServiceKeySet) Set(keys ...ServiceKey) {
+	copied := make([]ServiceKey, len(keys))
+	copy(copied, keys)
+	c.lock.Lock()
+	c.keys = copied
+	c.lock.Unlock()
+}
+
+// Keys returns the keys of the set
+func (c *ServiceKeySet) Keys() []ServiceKey {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := make([]ServiceKey, len(c.keys))
+	copy(res, c.keys)
+	return res
+}
+
+// Verify returns whether key matches an active key of the set
+// Every active key is compared in constant time so the result does not leak which key matched.
+func (c *ServiceKeySet) Verify(key string) bool {
+	now := time.Now()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	match := 0
+	for _, candidate := range c.keys {
+		if candidate.Key != "" && candidate.Active(now) {
+			match |= subtle.ConstantTimeCompare([]byte(candidate.Key), []byte(key))
+		}
+	}
+	return match == 1
+}
+
+// Current returns the active key which became valid most recently, used to sign outbound requests to IFTTT
+// Among active keys with the same NotBefore, the last one of the set is returned. ok is false if no key is active.
+func (c *ServiceKeySet) Current() (key string, ok bool) {
+	now := time.Now()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var since time.Time
+	for _, candidate := range c.keys {
+		if candidate.Key != "" && candidate.Active(now) && (!ok || !candidate.NotBefore.Before(since)) {
+			key, since, ok = candidate.Key, candidate.NotBefore, true
+		}
+	}
+	return key, ok
+}
+
+// Reload replaces the keys of the set with those returned by load, the keys are kept if load fails
+func (c *ServiceKeySet) Reload(load ServiceKeyLoader) error {
+	keys, err := load()
+	if err != nil {
+		return err
+	}
+	c.Set(keys...)
+	return nil
+}
+
+// ReloadOnSIGHUP calls Reload(load) each time the process receives SIGHUP until stop is called
+// Reload errors are passed to onError if it is not nil.
+func (c *ServiceKeySet) ReloadOnSIGHUP(load ServiceKeyLoader, onError func(err error)) (stop func()) {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-sig:
+				if err := c.Reload(load); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			close(done)
+		})
+	}
+}
+
+// parseServiceKey parses a key entry in the form of "key [not_before [not_after]]", times are in RFC 3339 format and "-" means no bound
+func parseServiceKey(entry string) (ServiceKey, error) {
+	parts := strings.Fields(entry)
+	res := ServiceKey{Key: parts[0]}
+	if len(parts) > 3 {
+		return ServiceKey{}, fmt.Errorf("Invalid service key entry with %d parts", len(parts))
+	}
+	bounds := []*time.Time{&res.NotBefore, &res.NotAfter}
+	for i, part := range parts[1:] {
+		if part == "-" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, part)
+		if err != nil {
+			return ServiceKey{}, fmt.Errorf("Invalid service key time %q", part)
+		}
+		*bounds[i] = parsed
+	}
+	return res, nil
+}
+
+// parseServiceKeys parses key entries separated by sep, skipping empty entries and comments starting with #
+func parseServiceKeys(text string, sep string) ([]ServiceKey, error) {
+	res := make([]ServiceKey, 0)
+	for _, entry := range strings.Split(text, sep) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		key, err := parseServiceKey(entry)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+	return res, nil
+}
+
+// ServiceKeysFromFile returns a ServiceKeyLoader reading the file at path
+// The file contains one key per line in the form of "key [not_before [not_after]]", where the times are in RFC 3339 format or "-" for no bound.
+// Empty lines and lines starting with # are ignored.
+func ServiceKeysFromFile(path string) ServiceKeyLoader {
+	return func() ([]ServiceKey, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseServiceKeys(string(data), "\n")
+	}
+}
+
+// ServiceKeysFromEnv returns a ServiceKeyLoader reading the environment variable name
+// The variable contains comma separated keys in the format described in ServiceKeysFromFile.
+func ServiceKeysFromEnv(name string) ServiceKeyLoader {
+	return func() ([]ServiceKey, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("Environment variable %s not set", name)
+		}
+		return parseServiceKeys(value, ",")
+	}
+}
+
+// outboundServiceKey returns the service key sent with requests to IFTTT: the current key of ServiceKeys if set, ServiceKey otherwise
+func (c *Service) outboundServiceKey() string {
+	if c.ServiceKeys != nil {
+		if key, ok := c.ServiceKeys.Current(); ok {
+			return key
+		}
+	}
+	return c.ServiceKey
+}
+
+// verifyServiceKey returns whether key matches ServiceKey or an active key of ServiceKeys
+func (c *Service) verifyServiceKey(key string) bool {
+	match := false
+	if c.ServiceKeys != nil {
+		match = c.ServiceKeys.Verify(key)
+	}
+	if c.ServiceKeys == nil || c.ServiceKey != "" {
+		if subtle.ConstantTimeCompare([]byte(c.ServiceKey), []byte(key)) == 1 {
+			match = true
+		}
+	}
+	return match
+}
@@ -0,0 +1,117 @@
+package ifttt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServiceKeySet(t *testing.T) {
+	now := time.Now()
+	set := NewServiceKeySet(
+		ServiceKey{Key: "current"},
+		ServiceKey{Key: "expired", NotAfter: now.Add(-time.Minute)},
+		ServiceKey{Key: "upcoming", NotBefore: now.Add(time.Minute)},
+		ServiceKey{Key: "rotating", NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Minute)},
+	)
+	for key, expected := range map[string]bool{
+		"current":  true,
+		"rotating": true,
+		"expired":  false,
+		"upcoming": false,
+		"":         false,
+		"curren":   false,
+	} {
+		if set.Verify(key) != expected {
+			t.Errorf("Unexpected verification of %q, expected %v\n", key, expected)
+			t.Fail()
+		}
+	}
+
+	if key, ok := set.Current(); !ok || key != "rotating" {
+		t.Errorf("Expected the most recent active key, got %q\n", key)
+		t.Fail()
+	}
+	if _, ok := NewServiceKeySet(ServiceKey{Key: "expired", NotAfter: now.Add(-time.Minute)}).Current(); ok {
+		t.Errorf("Expected no current key\n")
+		t.Fail()
+	}
+
+	service := &Service{ServiceKeys: set}
+	if key := service.outboundServiceKey(); key != "rotating" {
+		t.Errorf("Unexpected outbound key %q\n", key)
+		t.Fail()
+	}
+	if service.verifyServiceKey("") || !service.verifyServiceKey("current") {
+		t.Errorf("Unexpected verification without ServiceKey\n")
+		t.Fail()
+	}
+	service.ServiceKey = "legacy"
+	if !service.verifyServiceKey("legacy") || !service.verifyServiceKey("current") || service.verifyServiceKey("expired") {
+		t.Errorf("Unexpected verification with ServiceKey\n")
+		t.Fail()
+	}
+}
+
+func TestServiceKeyLoaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ifttt-servicekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+	ioutil.WriteFile(path, []byte("# rotated on 2019-01-01\nold - 2019-01-02T00:00:00Z\n\nnew 2019-01-01T00:00:00Z\n"), 0600)
+
+	keys, err := ServiceKeysFromFile(path)()
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Unexpected keys: %v %v\n", keys, err)
+	}
+	if keys[0].Key != "old" || !keys[0].NotBefore.IsZero() || !keys[0].NotAfter.Equal(time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected old key: %v\n", keys[0])
+		t.Fail()
+	}
+	if keys[1].Key != "new" || !keys[1].NotBefore.Equal(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)) || !keys[1].NotAfter.IsZero() {
+		t.Errorf("Unexpected new key: %v\n", keys[1])
+		t.Fail()
+	}
+
+	os.Setenv("IFTTT_TEST_SERVICE_KEYS", "foo, bar - -")
+	defer os.Unsetenv("IFTTT_TEST_SERVICE_KEYS")
+	if keys, err := ServiceKeysFromEnv("IFTTT_TEST_SERVICE_KEYS")(); err != nil || len(keys) != 2 || keys[0].Key != "foo" || keys[1].Key != "bar" {
+		t.Errorf("Unexpected keys from env: %v %v\n", keys, err)
+		t.Fail()
+	}
+	if _, err := ServiceKeysFromEnv("IFTTT_TEST_SERVICE_KEYS_MISSING")(); err == nil {
+		t.Errorf("Expected error on missing env\n")
+		t.Fail()
+	}
+	ioutil.WriteFile(path, []byte("key yesterday\n"), 0600)
+	if _, err := ServiceKeysFromFile(path)(); err == nil {
+		t.Errorf("Expected error on invalid time\n")
+		t.Fail()
+	}
+}
+
+func TestServiceKeyReloadOnSIGHUP(t *testing.T) {
+	set := NewServiceKeySet(ServiceKey{Key: "old"})
+	stop := set.ReloadOnSIGHUP(func() ([]ServiceKey, error) {
+		return []ServiceKey{{Key: "new"}}, nil
+	}, nil)
+	defer stop()
+
+	proc, _ := os.FindProcess(os.Getpid())
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("Cannot send SIGHUP: %v\n", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !set.Verify("new") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if set.Verify("old") || !set.Verify("new") {
+		t.Errorf("Unexpected keys after reload: %v\n", set.Keys())
+		t.Fail()
+	}
+}
@@ -0,0 +1,127 @@
+package ifttt
+
+import "github.com/Jeffail/gabs"
+
+// FieldValidationSample describes a pair of values used by the IFTTT endpoint tests to test dynamic field validation
+type FieldValidationSample struct {
+	// Valid a value which should pass the validation
+	Valid string
+	// Invalid a value which should fail the validation
+	Invalid string
+}
+
+// TestSetupResult represents the result returned to the test setup request
+// https://platform.ifttt.com/docs/testing#the-testsetup-endpoint
+type TestSetupResult struct {
+	// AccessToken is the token IFTTT would use in the following test requests, leave empty if your service does not require authentication
+	AccessToken string
+	// Triggers contains the sample trigger field values, keyed by trigger slug then field slug
+	Triggers map[string]map[string]string
+	// TriggerFieldValidations contains the sample values for trigger dynamic field validations, keyed by trigger slug then field slug
+	TriggerFieldValidations map[string]map[string]FieldValidationSample
+	// Actions contains the sample action field values, keyed by action slug then field slug
+	Actions map[string]map[string]string
+	// ActionRecordSkipping contains action field values which should make the action return a SKIP error, keyed by action slug then field slug
+	ActionRecordSkipping map[string]map[string]string
+	// Queries contains the sample query field values, keyed by query slug then field slug
+	Queries map[string]map[string]string
+}
+
+// TriggerSampler can be optionally implemented by a Trigger to declare the sample values used in the IFTTT endpoint tests.
+// If Service.TestSetup is nil, these samples are collected automatically from registered triggers.
+type TriggerSampler interface {
+	// SampleFields returns trigger field values which would make a valid trigger
+	SampleFields() map[string]string
+	// SampleValidations returns valid and invalid values of the fields which support dynamic validation
+	// If your trigger does not use dynamic validation, return nil here.
+	SampleValidations() map[string]FieldValidationSample
+}
+
+// ActionSampler can be optionally implemented by an Action to declare the sample values used in the IFTTT endpoint tests.
+// If Service.TestSetup is nil, these samples are collected automatically from registered actions.
+type ActionSampler interface {
+	// SampleFields returns action field values which would be handled successfully
+	SampleFields() map[string]string
+	// SampleSkipFields returns action field values which would make the action return a SKIP error
+	// If your action never skips, return nil here.
+	SampleSkipFields() map[string]string
+}
+
+func (c *Service) testSetupFromSamples(req *Request) (*TestSetupResult, error) {
+	res := &TestSetupResult{
+		AccessToken:             c.TestAccessToken,
+		Triggers:                make(map[string]map[string]string),
+		TriggerFieldValidations: make(map[string]map[string]FieldValidationSample),
+		Actions:                 make(map[string]map[string]string),
+		ActionRecordSkipping:    make(map[string]map[string]string),
+		Queries:                 make(map[string]map[string]string),
+	}
+	for slug, trigger := range c.triggers {
+		if sampler, ok := unwrapHandler(trigger).(TriggerSampler); ok {
+			if fields := sampler.SampleFields(); fields != nil {
+				res.Triggers[slug] = fields
+			}
+			if validations := sampler.SampleValidations(); validations != nil {
+				res.TriggerFieldValidations[slug] = validations
+			}
+		}
+	}
+	for slug, action := range c.actions {
+		if sampler, ok := unwrapHandler(action).(ActionSampler); ok {
+			if fields := sampler.SampleFields(); fields != nil {
+				res.Actions[slug] = fields
+			}
+			if fields := sampler.SampleSkipFields(); fields != nil {
+				res.ActionRecordSkipping[slug] = fields
+			}
+		}
+	}
+	for slug, query := range c.queries {
+		if sampler, ok := unwrapHandler(query).(QuerySampler); ok {
+			if fields := sampler.SampleFields(); fields != nil {
+				res.Queries[slug] = fields
+			}
+		}
+	}
+	return res, nil
+}
+
+func (c *TestSetupResult) marshal() []byte {
+	res := gabs.New()
+	if len(c.AccessToken) > 0 {
+		res.Set(c.AccessToken, "data", "accessToken")
+	}
+	res.Object("data", "samples")
+	for slug, fields := range c.Triggers {
+		res.Object("data", "samples", "triggers", slug)
+		for key, val := range fields {
+			res.Set(val, "data", "samples", "triggers", slug, key)
+		}
+	}
+	for slug, fields := range c.TriggerFieldValidations {
+		res.Object("data", "samples", "triggerFieldValidations", slug)
+		for key, val := range fields {
+			res.Set(val.Valid, "data", "samples", "triggerFieldValidations", slug, key, "valid")
+			res.Set(val.Invalid, "data", "samples", "triggerFieldValidations", slug, key, "invalid")
+		}
+	}
+	for slug, fields := range c.Actions {
+		res.Object("data", "samples", "actions", slug)
+		for key, val := range fields {
+			res.Set(val, "data", "samples", "actions", slug, key)
+		}
+	}
+	for slug, fields := range c.ActionRecordSkipping {
+		res.Object("data", "samples", "actionRecordSkipping", slug)
+		for key, val := range fields {
+			res.Set(val, "data", "samples", "actionRecordSkipping", slug, key)
+		}
+	}
+	for slug, fields := range c.Queries {
+		res.Object("data", "samples", "queries", slug)
+		for key, val := range fields {
+			res.Set(val, "data", "samples", "queries", slug, key)
+		}
+	}
+	return res.Bytes()
+}
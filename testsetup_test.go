@@ -0,0 +1,80 @@
+package ifttt
+
+import "testing"
+
+type sampledTrigger struct {
+	testTrigger
+}
+
+func (c sampledTrigger) SampleFields() map[string]string {
+	return map[string]string{
+		"album_name": "Street Art",
+	}
+}
+
+func (c sampledTrigger) SampleValidations() map[string]FieldValidationSample {
+	return map[string]FieldValidationSample{
+		"foo": {"bar", "baz"},
+	}
+}
+
+type sampledAction struct {
+	testAction
+}
+
+func (c sampledAction) SampleFields() map[string]string {
+	return map[string]string{
+		"URL": "http://example.com/images/125",
+	}
+}
+
+func (c sampledAction) SampleSkipFields() map[string]string {
+	return nil
+}
+
+func TestTestSetupMarshal(t *testing.T) {
+	res := TestSetupResult{}
+	if res := res.marshal(); !jsonEqual(res, []byte(`{"data":{"samples":{}}}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
+
+	res = TestSetupResult{
+		AccessToken: "realsecrettoken",
+		Triggers: map[string]map[string]string{
+			"test_trigger": {"foo": "bar"},
+		},
+		TriggerFieldValidations: map[string]map[string]FieldValidationSample{
+			"test_trigger": {"foo": {"bar", "baz"}},
+		},
+		Actions: map[string]map[string]string{
+			"test_action": {"URL": "http://example.com/"},
+		},
+		ActionRecordSkipping: map[string]map[string]string{
+			"test_action": {"URL": ""},
+		},
+	}
+	if res := res.marshal(); !jsonEqual(res, []byte(`{"data":{"accessToken":"realsecrettoken","samples":{"triggers":{"test_trigger":{"foo":"bar"}},"triggerFieldValidations":{"test_trigger":{"foo":{"valid":"bar","invalid":"baz"}}},"actions":{"test_action":{"URL":"http://example.com/"}},"actionRecordSkipping":{"test_action":{"URL":""}}}}}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
+}
+
+func TestTestSetupFromSamples(t *testing.T) {
+	service := &Service{
+		TestAccessToken: "realsecrettoken",
+	}
+	service.RegisterTrigger("sampled_trigger", sampledTrigger{})
+	service.RegisterTrigger("test_trigger", testTrigger{})
+	service.RegisterAction("sampled_action", sampledAction{})
+
+	setup, err := service.testSetupFromSamples(nil)
+	if err != nil {
+		t.Errorf("Unexpected error: %v\n", err)
+		t.Fail()
+	}
+	if res := setup.marshal(); !jsonEqual(res, []byte(`{"data":{"accessToken":"realsecrettoken","samples":{"triggers":{"sampled_trigger":{"album_name":"Street Art"}},"triggerFieldValidations":{"sampled_trigger":{"foo":{"valid":"bar","invalid":"baz"}}},"actions":{"sampled_action":{"URL":"http://example.com/images/125"}}}}}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
+}
@@ -0,0 +1,76 @@
+package ifttt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadTimezone(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Pacific Time (US & Canada)": "America/Los_Angeles",
+		"Tokyo":                      "Asia/Tokyo",
+		"Europe/Paris":               "Europe/Paris",
+	} {
+		loc, err := LoadTimezone(name)
+		if err != nil || loc.String() != expected {
+			t.Errorf("Unexpected location of %s: %v %v\n", name, loc, err)
+			t.Fail()
+		}
+	}
+	if _, err := LoadTimezone("Middle Earth"); err == nil {
+		t.Errorf("Expected error on unknown timezone\n")
+		t.Fail()
+	}
+}
+
+func TestUserContext(t *testing.T) {
+	fallback := time.FixedZone("fallback", 3600)
+	if ctx := newUserContext(map[string]string{}, nil); ctx.Resolved || ctx.Zone != time.UTC {
+		t.Errorf("Unexpected context for missing timezone: %v\n", ctx)
+		t.Fail()
+	}
+	if ctx := newUserContext(map[string]string{"timezone": "Middle Earth"}, fallback); ctx.Resolved || ctx.Zone != fallback || ctx.Timezone != "Middle Earth" {
+		t.Errorf("Unexpected context for unknown timezone: %v\n", ctx)
+		t.Fail()
+	}
+
+	ctx := newUserContext(map[string]string{"timezone": "Tokyo"}, fallback)
+	if !ctx.Resolved || ctx.Zone.String() != "Asia/Tokyo" {
+		t.Fatalf("Unexpected context: %v\n", ctx)
+	}
+
+	evt := ctx.EventTime(TriggerEventMeta{"1", time.Date(2019, 1, 2, 15, 0, 0, 0, time.UTC)})
+	if evt.Hour() != 0 || evt.Day() != 3 || evt.Location() != ctx.Zone {
+		t.Errorf("Unexpected event time: %v\n", evt)
+		t.Fail()
+	}
+
+	parsed, err := ctx.ParseDateTime("2019-01-02T09:00")
+	if err != nil || !parsed.Equal(time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected parsed time: %v %v\n", parsed, err)
+		t.Fail()
+	}
+	parsed, err = ctx.ParseDateTime("2019-01-02T09:00:00Z")
+	if err != nil || parsed.Hour() != 18 || parsed.Location() != ctx.Zone {
+		t.Errorf("Unexpected parsed time: %v %v\n", parsed, err)
+		t.Fail()
+	}
+
+	schema := FieldSchema{"due": {Type: DateTimeField}}
+	values, err := schema.DecodeInLocation(map[string]interface{}{"due": "2019-01-02T09:00:00", "raw": "2019-01-02"}, ctx.Zone)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if due := ctx.Time(values, "due"); !due.Equal(time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected due time: %v\n", due)
+		t.Fail()
+	}
+	if raw := ctx.Time(values, "raw"); !raw.Equal(time.Date(2019, 1, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected raw time: %v\n", raw)
+		t.Fail()
+	}
+	if missing := ctx.Time(values, "missing"); !missing.IsZero() {
+		t.Errorf("Unexpected missing time: %v\n", missing)
+		t.Fail()
+	}
+}
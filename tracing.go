@@ -0,0 +1,65 @@
+package ifttt
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Names of the spans started by Service
+const (
+	// SpanRequest spans the whole handling of a request from IFTTT, the other request spans are its children
+	SpanRequest = "ifttt.request"
+	// SpanParse spans parsing the request body
+	SpanParse = "ifttt.parse"
+	// SpanAuth spans verifying the service key, validating the request and authenticating the access token
+	SpanAuth = "ifttt.auth"
+	// SpanHandle spans the middlewares and the dispatch of the request to the registered handler, including marshaling its result
+	SpanHandle = "ifttt.handle"
+	// SpanMarshal spans marshaling errors and writing the response
+	SpanMarshal = "ifttt.marshal"
+	// SpanNotify spans a request to the IFTTT realtime API
+	SpanNotify = "ifttt.notify"
+)
+
+// Span is a traced unit of work
+type Span interface {
+	// SetAttributes adds attributes to the span, args are alternating keys and values
+	SetAttributes(args ...interface{})
+	// End ends the span, err is the error which failed the work or nil
+	End(err error)
+}
+
+// Tracer starts spans around the stages of handling requests and sending notifications
+// It is meant to be a thin adapter over a tracing library such as OpenTelemetry.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, the returned context carries the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// TracerFunc is an adapter to allow the use of ordinary functions as Tracer
+type TracerFunc func(ctx context.Context, name string) (context.Context, Span)
+
+// Start implements Tracer
+func (c TracerFunc) Start(ctx context.Context, name string) (context.Context, Span) {
+	return c(ctx, name)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(args ...interface{}) {}
+
+func (noopSpan) End(err error) {}
+
+// startSpan starts a span with Service.Tracer, or returns a span which does nothing if no tracer is set
+func (c *Service) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.Tracer.Start(ctx, name)
+}
+
+// newRequestID generates a request ID, used for requests which do not carry X-Request-ID and for outbound notifications
+func newRequestID() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
@@ -0,0 +1,169 @@
+package ifttt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	ended  bool
+	err    error
+}
+
+func (c *recordedSpan) SetAttributes(args ...interface{}) {
+	for i := 0; i+1 < len(args); i += 2 {
+		c.attrs[fmt.Sprint(args[i])] = args[i+1]
+	}
+}
+
+func (c *recordedSpan) End(err error) {
+	c.ended = true
+	c.err = err
+}
+
+type spanKey struct{}
+
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (c *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: make(map[string]interface{})}
+	c.lock.Lock()
+	c.spans = append(c.spans, span)
+	c.lock.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (c *recordingTracer) names() []string {
+	res := make([]string, len(c.spans))
+	for i, span := range c.spans {
+		res[i] = span.name
+	}
+	return res
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	service := &Service{ServiceKey: "servicekey", Tracer: tracer}
+	service.RegisterTrigger("test_trigger", testTrigger{})
+
+	req := httptest.NewRequest("POST", "/ifttt/v1/triggers/test_trigger", bytes.NewBufferString(`{"trigger_identity":"abcd","triggerFields":{"foo":"bar"},"user":{}}`))
+	req.Header.Set("IFTTT-Service-Key", "servicekey")
+	req.Header.Set("X-Request-ID", "abc")
+	res := httptest.NewRecorder()
+	service.ServeHTTP(res, req)
+
+	if res.Code != 200 || res.Header().Get("X-Request-ID") != "abc" {
+		t.Fatalf("Unexpected response: %d %v\n", res.Code, res.Header())
+	}
+	if names := tracer.names(); !stringSliceEqual(names, []string{SpanRequest, SpanParse, SpanAuth, SpanHandle, SpanMarshal}) {
+		t.Fatalf("Unexpected spans: %v\n", names)
+	}
+	root := tracer.spans[0]
+	for key, val := range map[string]interface{}{
+		"request_id":             "abc",
+		"ifttt.type":             "trigger_fetch",
+		"ifttt.slug":             "test_trigger",
+		"ifttt.trigger_identity": "abcd",
+		"http.status_code":       200,
+	} {
+		if root.attrs[key] != val {
+			t.Errorf("Unexpected attribute %s: %v\n", key, root.attrs[key])
+			t.Fail()
+		}
+	}
+	for _, span := range tracer.spans {
+		if !span.ended || span.err != nil {
+			t.Errorf("Span %s not ended cleanly: %v\n", span.name, span.err)
+			t.Fail()
+		}
+		if span != root && span.parent != root {
+			t.Errorf("Span %s is not a child of the request span\n", span.name)
+			t.Fail()
+		}
+	}
+
+	// the failing stage ends with the error and a request ID is generated when absent
+	tracer.spans = nil
+	req = httptest.NewRequest("GET", "/ifttt/v1/status", bytes.NewBufferString(""))
+	req.Header.Set("IFTTT-Service-Key", "wrongkey")
+	res = httptest.NewRecorder()
+	service.ServeHTTP(res, req)
+	generated := res.Header().Get("X-Request-ID")
+	if res.Code != 401 || generated == "" {
+		t.Fatalf("Unexpected response: %d %v\n", res.Code, res.Header())
+	}
+	if names := tracer.names(); !stringSliceEqual(names, []string{SpanRequest, SpanParse, SpanAuth, SpanMarshal}) {
+		t.Fatalf("Unexpected spans: %v\n", names)
+	}
+	if tracer.spans[0].attrs["request_id"] != generated || tracer.spans[0].err == nil || tracer.spans[2].err == nil || tracer.spans[3].err != nil {
+		t.Errorf("Unexpected spans of failed request: %v %v\n", tracer.spans[0], tracer.spans[2])
+		t.Fail()
+	}
+}
+
+func TestHandlerTracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	service := &Service{ServiceKey: "servicekey", Tracer: tracer, Timeouts: map[RequestType]time.Duration{TriggerFetch: time.Minute}}
+	service.RegisterTrigger("test_trigger", testTrigger{})
+	var deadline bool
+	service.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) *Response {
+			_, deadline = ctx.Deadline()
+			_, span := tracer.Start(ctx, "handler")
+			defer span.End(nil)
+			return next(ctx, req)
+		}
+	})
+
+	req := httptest.NewRequest("POST", "/ifttt/v1/triggers/test_trigger", bytes.NewBufferString(`{"trigger_identity":"abcd","triggerFields":{"foo":"bar"},"user":{}}`))
+	req.Header.Set("IFTTT-Service-Key", "servicekey")
+	res := httptest.NewRecorder()
+	service.ServeHTTP(res, req)
+
+	if res.Code != 200 {
+		t.Fatalf("Unexpected response: %d %s\n", res.Code, res.Body.String())
+	}
+	if names := tracer.names(); !stringSliceEqual(names, []string{SpanRequest, SpanParse, SpanAuth, SpanHandle, "handler", SpanMarshal}) {
+		t.Fatalf("Unexpected spans: %v\n", names)
+	}
+	if handler := tracer.spans[4]; handler.parent != tracer.spans[3] || !deadline {
+		t.Errorf("Expected the handler context to carry the handle span and the timeout, got %v %v\n", handler.parent, deadline)
+	}
+}
+
+func TestNotifyTracing(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Request-ID")
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	service := &Service{ServiceKey: "servicekey", Tracer: tracer, RealtimeURL: server.URL}
+	evt := Notification{}
+	evt.AddUser("foo")
+	if err := service.Notify(evt); err != nil {
+		t.Fatalf("Unexpected error: %v\n", err)
+	}
+	if len(tracer.spans) != 1 || tracer.spans[0].name != SpanNotify || !tracer.spans[0].ended {
+		t.Fatalf("Unexpected spans: %v\n", tracer.names())
+	}
+	if received == "" || tracer.spans[0].attrs["request_id"] != received || tracer.spans[0].attrs["ifttt.entries"] != 1 {
+		t.Errorf("Unexpected notify span attributes %v with request ID %q\n", tracer.spans[0].attrs, received)
+		t.Fail()
+	}
+}
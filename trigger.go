@@ -1,6 +1,7 @@
 package ifttt
 
 import (
+	"context"
 	"sort"
 	"time"
 
@@ -13,11 +14,18 @@ type TriggerPollRequest struct {
 	TriggerIdentity string
 	// TriggerFields the values of the trigger fields
 	TriggerFields map[string]string
+	// Fields are the decoded values of TriggerFields, typed according to the FieldSchema if the trigger implements SchemaProvider
+	Fields FieldValues
 	// Limit max number of events requested, you can return a little more but extra events will be ignored
 	Limit int
 	// User contains the metadata of the IFTTT user (eg: timezone)
 	User map[string]string
-	// TODO: IFTTT source
+	// UserContext contains the timezone of the IFTTT user resolved from User
+	UserContext UserContext
+	// Source describes the applet which initiated this request
+	Source Source
+	// Metadata contains other top level keys of the request body sent by IFTTT
+	Metadata map[string]interface{}
 }
 
 // TriggerEventCollection a slice of TriggerEvent, the events returned to a trigger poll
@@ -86,6 +94,15 @@ func (c TriggerEventCollection) Swap(i, j int) {
 	c[i], c[j] = c[j], c[i]
 }
 
+// limit returns at most n newest events of the collection, n < 0 for unlimited
+func (c TriggerEventCollection) limit(n int) TriggerEventCollection {
+	sort.Sort(c)
+	if n >= 0 && n < len(c) {
+		return c[:n]
+	}
+	return c
+}
+
 func (c TriggerEventCollection) marshal() []byte {
 	sort.Sort(c)
 
@@ -103,3 +120,45 @@ func (c TriggerEventCollection) marshal() []byte {
 	}
 	return res.Bytes()
 }
+
+// TriggerWithContext is the context-aware variant of Trigger.
+// Register it with Service.RegisterTriggerWithContext.
+type TriggerWithContext interface {
+	// PollWithContext is the context-aware variant of Trigger.Poll
+	PollWithContext(ctx context.Context, req *TriggerPollRequest, r *Request) (TriggerEventCollection, error)
+	// OptionsWithContext is the context-aware variant of Trigger.Options
+	OptionsWithContext(ctx context.Context, req *Request) (*DynamicOption, error)
+	// ValidateFieldWithContext is the context-aware variant of Trigger.ValidateField
+	ValidateFieldWithContext(ctx context.Context, fieldslug string, value string, req *Request) error
+	// ValidateContextWithContext is the context-aware variant of Trigger.ValidateContext
+	ValidateContextWithContext(ctx context.Context, values map[string]string, req *Request) (map[string]error, error)
+	// RemoveIdentityWithContext is the context-aware variant of Trigger.RemoveIdentity
+	RemoveIdentityWithContext(ctx context.Context, triggerid string) error
+	// RealTime should return whether this service supports the IFTTT real-time API.
+	RealTime() bool
+}
+
+// triggerAdapter adapts a Trigger to TriggerWithContext by ignoring the context
+type triggerAdapter struct {
+	Trigger
+}
+
+func (c triggerAdapter) PollWithContext(ctx context.Context, req *TriggerPollRequest, r *Request) (TriggerEventCollection, error) {
+	return c.Poll(req, r)
+}
+
+func (c triggerAdapter) OptionsWithContext(ctx context.Context, req *Request) (*DynamicOption, error) {
+	return c.Options(req)
+}
+
+func (c triggerAdapter) ValidateFieldWithContext(ctx context.Context, fieldslug string, value string, req *Request) error {
+	return c.ValidateField(fieldslug, value, req)
+}
+
+func (c triggerAdapter) ValidateContextWithContext(ctx context.Context, values map[string]string, req *Request) (map[string]error, error) {
+	return c.ValidateContext(values, req)
+}
+
+func (c triggerAdapter) RemoveIdentityWithContext(ctx context.Context, triggerid string) error {
+	return c.RemoveIdentity(triggerid)
+}
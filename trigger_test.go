@@ -32,4 +32,14 @@ func TestTriggerEventCollection(t *testing.T) {
 		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
 		t.Fail()
 	}
+
+	if res := col.limit(1).marshal(); !jsonEqual(res, []byte(`{"data":[{"foo":"bar","meta":{"id":"2","timestamp":200000}}]}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
+
+	if res := col.limit(0).marshal(); !jsonEqual(res, []byte(`{"data":[]}`)) {
+		t.Errorf("MarshalError: Unexpected JSON: %s\n", res)
+		t.Fail()
+	}
 }